@@ -0,0 +1,113 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/go-playground/validator/v10"
+	"github.com/labstack/echo/v4"
+	"github.com/mayank12gt/movie-webapp/internal/data"
+)
+
+func (app *app) createAPIKeyHandler() func(c echo.Context) error {
+	return func(c echo.Context) error {
+
+		var input struct {
+			Name        string     `json:"name" validate:"required,max=100"`
+			Permissions []string   `json:"permissions" validate:"required,min=1,unique,dive,required"`
+			Expiry      *time.Time `json:"expiry"`
+		}
+
+		if err := c.Bind(&input); err != nil {
+			return c.JSON(http.StatusBadRequest, map[string]string{
+				"message": "Bad Request, verify json body",
+			})
+		}
+
+		validate := validator.New()
+		if err := validate.Struct(input); err != nil {
+			return c.JSON(422, map[string]string{
+				"message": err.Error(),
+			})
+		}
+		if input.Expiry != nil && !input.Expiry.After(time.Now()) {
+			return c.JSON(422, map[string]string{
+				"message": "expiry must be in the future",
+			})
+		}
+
+		user := c.Get("user").(*data.User)
+
+		granted, err := app.models.Permissions.GetAllForUser(user.ID)
+		if err != nil {
+			return c.JSON(500, map[string]string{
+				"message": "Internal Server Error",
+			})
+		}
+		for _, code := range input.Permissions {
+			if !granted.Include(code) {
+				return c.JSON(422, map[string]string{
+					"message": "an API key cannot be given permission " + code + ", which the user does not have",
+				})
+			}
+		}
+
+		key, err := app.models.Tokens.NewAPIKey(user.ID, input.Name, input.Expiry, input.Permissions)
+		if err != nil {
+			return c.JSON(500, map[string]string{
+				"message": "Internal Server Error",
+			})
+		}
+
+		return c.JSON(http.StatusCreated, key)
+	}
+}
+
+func (app *app) listAPIKeysHandler() func(c echo.Context) error {
+	return func(c echo.Context) error {
+
+		user := c.Get("user").(*data.User)
+
+		keys, err := app.models.Tokens.GetAPIKeysForUser(user.ID)
+		if err != nil {
+			return c.JSON(500, map[string]string{
+				"message": "Internal Server Error",
+			})
+		}
+
+		return c.JSON(200, map[string]interface{}{
+			"api_keys": keys,
+		})
+	}
+}
+
+func (app *app) deleteAPIKeyHandler() func(c echo.Context) error {
+	return func(c echo.Context) error {
+
+		keyID, err := readIDParam(c, "id")
+		if err != nil {
+			return c.JSON(http.StatusBadRequest, map[string]string{
+				"message": err.Error(),
+			})
+		}
+
+		user := c.Get("user").(*data.User)
+
+		err = app.models.Tokens.DeleteForUser(user.ID, keyID, data.ScopeAPIKey)
+		if err != nil {
+			if errors.Is(err, data.ErrRecordNotFound) {
+				return c.JSON(404, map[string]string{
+					"message": "records not found",
+				})
+			}
+			return c.JSON(500, map[string]string{
+				"message": "Internal Server Error",
+			})
+		}
+
+		return c.JSON(200, map[string]string{
+			"message": "API key deleted",
+		})
+	}
+}
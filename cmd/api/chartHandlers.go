@@ -0,0 +1,126 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/go-playground/validator/v10"
+	"github.com/labstack/echo/v4"
+	"github.com/mayank12gt/movie-webapp/internal/data"
+)
+
+// topRatedHandler ranks movies by weighted rating. Only movies with at least
+// -rating-min-votes ratings are charted, unless ?min_votes= asks otherwise.
+func (app *app) topRatedHandler() func(c echo.Context) error {
+	return func(c echo.Context) error {
+		filters, err := readFilters(c, "rating", []string{"rating"})
+		if err != nil {
+			return c.JSON(http.StatusBadRequest, map[string]string{
+				"message": err.Error(),
+			})
+		}
+
+		validate := validator.New()
+		if err := validate.Struct(filters); err != nil {
+			return c.JSON(422, map[string]string{
+				"message": err.Error(),
+			})
+		}
+		if !filters.ValidSort() {
+			return c.JSON(422, map[string]string{
+				"message": "sort must be rating",
+			})
+		}
+
+		minVotes := app.config.ratings.minVotes
+		if c.QueryParam("min_votes") != "" {
+			minVotes, err = strconv.Atoi(c.QueryParam("min_votes"))
+			if err != nil || minVotes < 1 {
+				return c.JSON(http.StatusBadRequest, map[string]string{
+					"message": "min_votes must be a positive integer",
+				})
+			}
+		}
+
+		movies, meta, err := app.models.Movies.TopRated(c.QueryParam("genre"), minVotes, filters)
+		if err != nil {
+			return c.JSON(500, map[string]string{
+				"message": "Internal Server Error",
+			})
+		}
+
+		if err := app.loadImages(movies...); err != nil {
+			return c.JSON(500, map[string]string{
+				"message": "Internal Server Error",
+			})
+		}
+
+		return c.JSON(200, map[string]interface{}{
+			"metadata":  meta,
+			"min_votes": minVotes,
+			"movies":    movies,
+		})
+	}
+}
+
+var trendingWindows = []string{"day", "week", "month"}
+
+// trendingHandler ranks movies by recent views, ratings, reviews and
+// watchlist adds, with older activity counting for less.
+func (app *app) trendingHandler() func(c echo.Context) error {
+	return func(c echo.Context) error {
+		filters, err := readFilters(c, "score", []string{"score"})
+		if err != nil {
+			return c.JSON(http.StatusBadRequest, map[string]string{
+				"message": err.Error(),
+			})
+		}
+
+		validate := validator.New()
+		if err := validate.Struct(filters); err != nil {
+			return c.JSON(422, map[string]string{
+				"message": err.Error(),
+			})
+		}
+		if !filters.ValidSort() {
+			return c.JSON(422, map[string]string{
+				"message": "sort must be score",
+			})
+		}
+
+		name := c.QueryParam("window")
+		if name == "" {
+			name = "week"
+		}
+		window, ok := data.TrendingWindows[name]
+		if !ok {
+			return c.JSON(422, map[string]string{
+				"message": "window must be one of " + strings.Join(trendingWindows, " "),
+			})
+		}
+
+		trending, meta, err := app.models.Trending.Trending(window, filters)
+		if err != nil {
+			return c.JSON(500, map[string]string{
+				"message": "Internal Server Error",
+			})
+		}
+
+		movies := make([]*data.Movie, len(trending))
+		for i, entry := range trending {
+			movies[i] = entry.Movie
+		}
+		if err := app.loadImages(movies...); err != nil {
+			return c.JSON(500, map[string]string{
+				"message": "Internal Server Error",
+			})
+		}
+
+		return c.JSON(200, map[string]interface{}{
+			"metadata": meta,
+			"window":   name,
+			"movies":   trending,
+		})
+	}
+}
@@ -0,0 +1,180 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-playground/validator/v10"
+	"github.com/labstack/echo/v4"
+	"github.com/mayank12gt/movie-webapp/internal/data"
+)
+
+var diarySortSafelist = []string{"watched_on", "-watched_on"}
+
+func (app *app) createDiaryEntryHandler() func(c echo.Context) error {
+	return func(c echo.Context) error {
+		var input struct {
+			MovieID   int64    `json:"movie_id"`
+			WatchedOn string   `json:"watched_on"`
+			Rewatch   *bool    `json:"rewatch"`
+			Rating    *float64 `json:"rating"`
+			Note      string   `json:"note"`
+		}
+
+		if err := c.Bind(&input); err != nil {
+			return c.JSON(http.StatusBadRequest, map[string]string{
+				"message": "Bad Request, verify the json body",
+			})
+		}
+
+		entry := &data.DiaryEntry{
+			MovieID:   input.MovieID,
+			WatchedOn: input.WatchedOn,
+			Rating:    input.Rating,
+			Note:      strings.TrimSpace(input.Note),
+		}
+		if entry.WatchedOn == "" {
+			entry.WatchedOn = time.Now().Format(data.DateLayout)
+		}
+
+		validate := validator.New()
+		if err := validate.Struct(entry); err != nil {
+			return c.JSON(422, map[string]string{
+				"message": err.Error(),
+			})
+		}
+
+		// A day of slack lets clients ahead of the server's timezone log
+		// what they watched tonight.
+		if watchedOn, _ := time.Parse(data.DateLayout, entry.WatchedOn); watchedOn.After(time.Now().AddDate(0, 0, 1)) {
+			return c.JSON(422, map[string]string{
+				"message": "watched_on cannot be in the future",
+			})
+		}
+		if entry.Rating != nil && !app.config.ratings.scale.Valid(*entry.Rating) {
+			return c.JSON(422, map[string]string{
+				"message": "rating must be " + app.config.ratings.scale.String(),
+			})
+		}
+
+		user := c.Get("user").(*data.User)
+
+		if err := app.models.Diary.Insert(user.ID, entry, input.Rewatch); err != nil {
+			if errors.Is(err, data.ErrRecordNotFound) {
+				return c.JSON(404, map[string]string{
+					"message": "records not found",
+				})
+			}
+			return c.JSON(500, map[string]string{
+				"message": "Internal Server Error",
+			})
+		}
+
+		return c.JSON(201, entry)
+	}
+}
+
+func (app *app) listDiaryHandler() func(c echo.Context) error {
+	return func(c echo.Context) error {
+		filters, err := readFilters(c, "-watched_on", diarySortSafelist)
+		if err != nil {
+			return c.JSON(http.StatusBadRequest, map[string]string{
+				"message": err.Error(),
+			})
+		}
+
+		validate := validator.New()
+		if err := validate.Struct(filters); err != nil {
+			return c.JSON(422, map[string]string{
+				"message": err.Error(),
+			})
+		}
+		if !filters.ValidSort() {
+			return c.JSON(422, map[string]string{
+				"message": "sort must be one of " + strings.Join(diarySortSafelist, " "),
+			})
+		}
+
+		from, to := c.QueryParam("from"), c.QueryParam("to")
+		for name, value := range map[string]string{"from": from, "to": to} {
+			if _, err := time.Parse(data.DateLayout, value); value != "" && err != nil {
+				return c.JSON(http.StatusBadRequest, map[string]string{
+					"message": name + " must be a date like 2006-01-02",
+				})
+			}
+		}
+
+		user := c.Get("user").(*data.User)
+
+		entries, meta, err := app.models.Diary.List(user.ID, from, to, filters)
+		if err != nil {
+			return c.JSON(500, map[string]string{
+				"message": "Internal Server Error",
+			})
+		}
+
+		return c.JSON(200, map[string]interface{}{
+			"metadata": meta,
+			"diary":    entries,
+		})
+	}
+}
+
+func (app *app) deleteDiaryEntryHandler() func(c echo.Context) error {
+	return func(c echo.Context) error {
+		entryID, err := readIDParam(c, "id")
+		if err != nil {
+			return c.JSON(http.StatusBadRequest, map[string]string{
+				"message": err.Error(),
+			})
+		}
+
+		user := c.Get("user").(*data.User)
+
+		if err := app.models.Diary.Delete(user.ID, entryID); err != nil {
+			if errors.Is(err, data.ErrRecordNotFound) {
+				return c.JSON(404, map[string]string{
+					"message": "records not found",
+				})
+			}
+			return c.JSON(500, map[string]string{
+				"message": "Internal Server Error",
+			})
+		}
+
+		return c.JSON(200, map[string]string{
+			"message": "Diary entry deleted",
+		})
+	}
+}
+
+func (app *app) diaryStatsHandler() func(c echo.Context) error {
+	return func(c echo.Context) error {
+		var year int
+		if c.QueryParam("year") != "" {
+			var err error
+			year, err = strconv.Atoi(c.QueryParam("year"))
+			if err != nil || year < 1888 {
+				return c.JSON(http.StatusBadRequest, map[string]string{
+					"message": "year must be an integer from 1888",
+				})
+			}
+		}
+
+		user := c.Get("user").(*data.User)
+
+		years, err := app.models.Diary.YearlyStats(user.ID, year)
+		if err != nil {
+			return c.JSON(500, map[string]string{
+				"message": "Internal Server Error",
+			})
+		}
+
+		return c.JSON(200, map[string]interface{}{
+			"years": years,
+		})
+	}
+}
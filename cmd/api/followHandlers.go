@@ -0,0 +1,306 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/go-playground/validator/v10"
+	"github.com/labstack/echo/v4"
+	"github.com/mayank12gt/movie-webapp/internal/data"
+)
+
+func (app *app) followUserHandler() func(c echo.Context) error {
+	return func(c echo.Context) error {
+		followeeID, err := readIDParam(c, "id")
+		if err != nil {
+			return c.JSON(http.StatusBadRequest, map[string]string{
+				"message": err.Error(),
+			})
+		}
+
+		user := c.Get("user").(*data.User)
+
+		status, err := app.models.Follows.Follow(user.ID, followeeID)
+		if err != nil {
+			switch {
+			case errors.Is(err, data.ErrSelfFollow):
+				return c.JSON(422, map[string]string{
+					"message": err.Error(),
+				})
+			case errors.Is(err, data.ErrRecordNotFound):
+				return c.JSON(404, map[string]string{
+					"message": "records not found",
+				})
+			default:
+				return c.JSON(500, map[string]string{
+					"message": "Internal Server Error",
+				})
+			}
+		}
+
+		code := http.StatusOK
+		if status == data.FollowPending {
+			code = http.StatusAccepted
+		}
+
+		return c.JSON(code, map[string]string{
+			"status": status,
+		})
+	}
+}
+
+func (app *app) unfollowUserHandler() func(c echo.Context) error {
+	return func(c echo.Context) error {
+		followeeID, err := readIDParam(c, "id")
+		if err != nil {
+			return c.JSON(http.StatusBadRequest, map[string]string{
+				"message": err.Error(),
+			})
+		}
+
+		user := c.Get("user").(*data.User)
+
+		if err := app.models.Follows.Unfollow(user.ID, followeeID); err != nil {
+			if errors.Is(err, data.ErrRecordNotFound) {
+				return c.JSON(404, map[string]string{
+					"message": "records not found",
+				})
+			}
+			return c.JSON(500, map[string]string{
+				"message": "Internal Server Error",
+			})
+		}
+
+		return c.JSON(200, map[string]string{
+			"message": "Unfollowed",
+		})
+	}
+}
+
+func (app *app) listFollowersHandler() func(c echo.Context) error {
+	return app.listFollows(func(userID int64, filters data.Filters) ([]*data.Follower, data.Metadata, error) {
+		return app.models.Follows.Followers(userID, filters)
+	})
+}
+
+func (app *app) listFollowingHandler() func(c echo.Context) error {
+	return app.listFollows(func(userID int64, filters data.Filters) ([]*data.Follower, data.Metadata, error) {
+		return app.models.Follows.Following(userID, filters)
+	})
+}
+
+// listFollows serves the follower and following lists of the :id user. For
+// private accounts, they are only shown to the owner and accepted followers.
+func (app *app) listFollows(list func(userID int64, filters data.Filters) ([]*data.Follower, data.Metadata, error)) func(c echo.Context) error {
+	return func(c echo.Context) error {
+		userID, err := readIDParam(c, "id")
+		if err != nil {
+			return c.JSON(http.StatusBadRequest, map[string]string{
+				"message": err.Error(),
+			})
+		}
+
+		filters, err := readFilters(c, "newest", []string{"newest"})
+		if err != nil {
+			return c.JSON(http.StatusBadRequest, map[string]string{
+				"message": err.Error(),
+			})
+		}
+
+		validate := validator.New()
+		if err := validate.Struct(filters); err != nil {
+			return c.JSON(422, map[string]string{
+				"message": err.Error(),
+			})
+		}
+		if !filters.ValidSort() {
+			return c.JSON(422, map[string]string{
+				"message": "sort must be newest",
+			})
+		}
+
+		allowed, err := app.models.Follows.CanView(currentUserID(c), userID)
+		if err != nil && !errors.Is(err, data.ErrRecordNotFound) {
+			return c.JSON(500, map[string]string{
+				"message": "Internal Server Error",
+			})
+		}
+		if !allowed {
+			return c.JSON(404, map[string]string{
+				"message": "records not found",
+			})
+		}
+
+		users, meta, err := list(userID, filters)
+		if err != nil {
+			return c.JSON(500, map[string]string{
+				"message": "Internal Server Error",
+			})
+		}
+
+		return c.JSON(200, map[string]interface{}{
+			"metadata": meta,
+			"users":    users,
+		})
+	}
+}
+
+func (app *app) listFollowRequestsHandler() func(c echo.Context) error {
+	return func(c echo.Context) error {
+		filters, err := readFilters(c, "newest", []string{"newest"})
+		if err != nil {
+			return c.JSON(http.StatusBadRequest, map[string]string{
+				"message": err.Error(),
+			})
+		}
+
+		validate := validator.New()
+		if err := validate.Struct(filters); err != nil {
+			return c.JSON(422, map[string]string{
+				"message": err.Error(),
+			})
+		}
+		if !filters.ValidSort() {
+			return c.JSON(422, map[string]string{
+				"message": "sort must be newest",
+			})
+		}
+
+		user := c.Get("user").(*data.User)
+
+		requests, meta, err := app.models.Follows.Requests(user.ID, filters)
+		if err != nil {
+			return c.JSON(500, map[string]string{
+				"message": "Internal Server Error",
+			})
+		}
+
+		return c.JSON(200, map[string]interface{}{
+			"metadata": meta,
+			"requests": requests,
+		})
+	}
+}
+
+func (app *app) approveFollowRequestHandler() func(c echo.Context) error {
+	return app.answerFollowRequest("Follow request approved", func(ownerID, followerID int64) error {
+		return app.models.Follows.Approve(ownerID, followerID)
+	})
+}
+
+func (app *app) rejectFollowRequestHandler() func(c echo.Context) error {
+	return app.answerFollowRequest("Follow request rejected", func(ownerID, followerID int64) error {
+		return app.models.Follows.Reject(ownerID, followerID)
+	})
+}
+
+// answerFollowRequest handles the caller's answer to the follow request from
+// the :id user.
+func (app *app) answerFollowRequest(message string, answer func(ownerID, followerID int64) error) func(c echo.Context) error {
+	return func(c echo.Context) error {
+		followerID, err := readIDParam(c, "id")
+		if err != nil {
+			return c.JSON(http.StatusBadRequest, map[string]string{
+				"message": err.Error(),
+			})
+		}
+
+		user := c.Get("user").(*data.User)
+
+		if err := answer(user.ID, followerID); err != nil {
+			if errors.Is(err, data.ErrRecordNotFound) {
+				return c.JSON(404, map[string]string{
+					"message": "records not found",
+				})
+			}
+			return c.JSON(500, map[string]string{
+				"message": "Internal Server Error",
+			})
+		}
+
+		return c.JSON(200, map[string]string{
+			"message": message,
+		})
+	}
+}
+
+func (app *app) setPrivacyHandler() func(c echo.Context) error {
+	return func(c echo.Context) error {
+		var input struct {
+			Private *bool `json:"private" validate:"required"`
+		}
+
+		if err := c.Bind(&input); err != nil {
+			return c.JSON(http.StatusBadRequest, map[string]string{
+				"message": "Bad Request, verify the json body",
+			})
+		}
+
+		validate := validator.New()
+		if err := validate.Struct(input); err != nil {
+			return c.JSON(422, map[string]string{
+				"message": err.Error(),
+			})
+		}
+
+		user := c.Get("user").(*data.User)
+
+		if err := app.models.Follows.SetPrivate(user.ID, *input.Private); err != nil {
+			return c.JSON(500, map[string]string{
+				"message": "Internal Server Error",
+			})
+		}
+
+		return c.JSON(200, map[string]bool{
+			"private": *input.Private,
+		})
+	}
+}
+
+// feedHandler returns the activity of followed users, newest first. Pages
+// are linked by cursor rather than page number, so new activity arriving
+// between requests doesn't shift items onto the next page twice.
+func (app *app) feedHandler() func(c echo.Context) error {
+	return func(c echo.Context) error {
+		var cursor int64
+		if c.QueryParam("cursor") != "" {
+			var err error
+			cursor, err = strconv.ParseInt(c.QueryParam("cursor"), 10, 64)
+			if err != nil || cursor < 1 {
+				return c.JSON(http.StatusBadRequest, map[string]string{
+					"message": "cursor must be a positive integer",
+				})
+			}
+		}
+
+		limit := 20
+		if c.QueryParam("limit") != "" {
+			var err error
+			limit, err = strconv.Atoi(c.QueryParam("limit"))
+			if err != nil || limit < 1 || limit > 100 {
+				return c.JSON(http.StatusBadRequest, map[string]string{
+					"message": "limit must be an integer between 1 and 100",
+				})
+			}
+		}
+
+		user := c.Get("user").(*data.User)
+
+		events, err := app.models.Activity.Feed(user.ID, cursor, limit)
+		if err != nil {
+			return c.JSON(500, map[string]string{
+				"message": "Internal Server Error",
+			})
+		}
+
+		response := map[string]interface{}{
+			"events": events,
+		}
+		if len(events) == limit {
+			response["next_cursor"] = events[len(events)-1].ID
+		}
+
+		return c.JSON(200, response)
+	}
+}
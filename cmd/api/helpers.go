@@ -1,5 +1,13 @@
 package main
 
+import (
+	"errors"
+	"strconv"
+
+	"github.com/labstack/echo/v4"
+	"github.com/mayank12gt/movie-webapp/internal/data"
+)
+
 func (app *app) background(fn func()) {
 
 	app.wg.Add(1)
@@ -14,3 +22,53 @@ func (app *app) background(fn func()) {
 		fn()
 	}()
 }
+
+// readIDParam parses a positive integer path parameter such as :id.
+func readIDParam(c echo.Context, name string) (int64, error) {
+	id, err := strconv.ParseInt(c.Param(name), 10, 64)
+	if err != nil || id < 1 {
+		return 0, errors.New(name + " must be a positive integer")
+	}
+	return id, nil
+}
+
+// readFilters reads the page, page_size and sort query parameters shared by
+// every paginated endpoint. The returned filters still need to be validated.
+func readFilters(c echo.Context, defaultSort string, sortSafelist []string) (data.Filters, error) {
+	filters := data.Filters{
+		Page:         1,
+		PageSize:     20,
+		Sort:         defaultSort,
+		SortSafelist: sortSafelist,
+	}
+
+	var err error
+	if c.QueryParam("page") != "" {
+		filters.Page, err = strconv.Atoi(c.QueryParam("page"))
+		if err != nil {
+			return filters, errors.New("page must be integer")
+		}
+	}
+
+	if c.QueryParam("page_size") != "" {
+		filters.PageSize, err = strconv.Atoi(c.QueryParam("page_size"))
+		if err != nil {
+			return filters, errors.New("page_size must be integer")
+		}
+	}
+
+	if c.QueryParam("sort") != "" {
+		filters.Sort = c.QueryParam("sort")
+	}
+
+	return filters, nil
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
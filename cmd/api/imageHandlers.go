@@ -0,0 +1,244 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/mayank12gt/movie-webapp/internal/data"
+	"github.com/mayank12gt/movie-webapp/internal/images"
+)
+
+// maxImageUpload is the largest image file accepted.
+const maxImageUpload = 10 << 20
+
+var imageKinds = map[string][]images.Size{
+	data.ImagePoster:   images.PosterSizes,
+	data.ImageBackdrop: images.BackdropSizes,
+}
+
+// uploadMovieImageHandler takes a multipart upload with the file in the
+// "image" field and "kind" set to poster (the default) or backdrop. It
+// replaces the movie's current image of that kind.
+func (app *app) uploadMovieImageHandler() func(c echo.Context) error {
+	return func(c echo.Context) error {
+		movieID, err := readIDParam(c, "id")
+		if err != nil {
+			return c.JSON(http.StatusBadRequest, map[string]string{
+				"message": err.Error(),
+			})
+		}
+
+		// Leave room for the rest of the form around the file.
+		c.Request().Body = http.MaxBytesReader(c.Response(), c.Request().Body, maxImageUpload+1<<20)
+		if err := c.Request().ParseMultipartForm(1 << 20); err != nil {
+			var maxBytesErr *http.MaxBytesError
+			if errors.As(err, &maxBytesErr) {
+				return c.JSON(http.StatusRequestEntityTooLarge, map[string]string{
+					"message": fmt.Sprintf("image must be at most %d MB", maxImageUpload>>20),
+				})
+			}
+			return c.JSON(http.StatusBadRequest, map[string]string{
+				"message": "Bad Request, send the image as multipart form data in the image field",
+			})
+		}
+
+		kind := c.FormValue("kind")
+		if kind == "" {
+			kind = data.ImagePoster
+		}
+		sizes, ok := imageKinds[kind]
+		if !ok {
+			return c.JSON(422, map[string]string{
+				"message": "kind must be one of poster backdrop",
+			})
+		}
+
+		upload, err := c.FormFile("image")
+		if err != nil {
+			return c.JSON(http.StatusBadRequest, map[string]string{
+				"message": "Bad Request, send the image as multipart form data in the image field",
+			})
+		}
+		if upload.Size > maxImageUpload {
+			return c.JSON(http.StatusRequestEntityTooLarge, map[string]string{
+				"message": fmt.Sprintf("image must be at most %d MB", maxImageUpload>>20),
+			})
+		}
+
+		file, err := upload.Open()
+		if err != nil {
+			return c.JSON(500, map[string]string{
+				"message": "Internal Server Error",
+			})
+		}
+		content, err := io.ReadAll(file)
+		file.Close()
+		if err != nil {
+			return c.JSON(500, map[string]string{
+				"message": "Internal Server Error",
+			})
+		}
+
+		if _, err := app.models.Movies.Get(movieID); err != nil {
+			if errors.Is(err, sql.ErrNoRows) || errors.Is(err, data.ErrRecordNotFound) {
+				return c.JSON(404, map[string]string{
+					"message": "records not found",
+				})
+			}
+			return c.JSON(500, map[string]string{
+				"message": "Internal Server Error",
+			})
+		}
+
+		variants, err := images.Process(content, sizes)
+		if err != nil {
+			switch {
+			case errors.Is(err, images.ErrUnsupportedFormat):
+				return c.JSON(http.StatusUnsupportedMediaType, map[string]string{
+					"message": err.Error(),
+				})
+			case errors.Is(err, images.ErrInvalidImage), errors.Is(err, images.ErrTooLarge):
+				return c.JSON(422, map[string]string{
+					"message": err.Error(),
+				})
+			default:
+				return c.JSON(500, map[string]string{
+					"message": "Internal Server Error",
+				})
+			}
+		}
+
+		image, err := app.storeImage(c.Request().Context(), movieID, kind, variants)
+		if err != nil {
+			return c.JSON(500, map[string]string{
+				"message": "Internal Server Error",
+			})
+		}
+
+		replaced, err := app.models.Images.Replace(image)
+		if err != nil {
+			app.deleteImageFiles(image)
+			if errors.Is(err, data.ErrRecordNotFound) {
+				return c.JSON(404, map[string]string{
+					"message": "records not found",
+				})
+			}
+			return c.JSON(500, map[string]string{
+				"message": "Internal Server Error",
+			})
+		}
+		if replaced != nil {
+			app.background(func() {
+				app.deleteImageFiles(replaced)
+			})
+		}
+
+		app.setImageURLs(image)
+		return c.JSON(http.StatusCreated, image)
+	}
+}
+
+// storeImage puts every variant in the image store under a fresh random
+// prefix, so a replaced image never shares a URL with the one before it.
+func (app *app) storeImage(ctx context.Context, movieID int64, kind string, variants []*images.Variant) (*data.MovieImage, error) {
+	random := make([]byte, 8)
+	if _, err := rand.Read(random); err != nil {
+		return nil, err
+	}
+	prefix := fmt.Sprintf("movies/%d/%s-%s", movieID, kind, hex.EncodeToString(random))
+
+	image := &data.MovieImage{MovieID: movieID, Kind: kind, Variants: map[string]*data.ImageVariant{}}
+	for _, variant := range variants {
+		key := prefix + "-" + variant.Name + variant.Extension
+		if err := app.images.Put(ctx, key, variant.Data, variant.ContentType); err != nil {
+			app.deleteImageFiles(image)
+			return nil, err
+		}
+		image.Variants[variant.Name] = &data.ImageVariant{
+			Key:         key,
+			Width:       variant.Width,
+			Height:      variant.Height,
+			ContentType: variant.ContentType,
+		}
+	}
+
+	return image, nil
+}
+
+func (app *app) deleteImageFiles(image *data.MovieImage) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	for _, variant := range image.Variants {
+		if err := app.images.Delete(ctx, variant.Key); err != nil {
+			app.logger.Printf("deleting image %s: %v", variant.Key, err)
+		}
+	}
+}
+
+// loadImages fills in the images of the given movies, with one query for
+// all of them.
+func (app *app) loadImages(movies ...*data.Movie) error {
+	if len(movies) == 0 {
+		return nil
+	}
+
+	ids := make([]int64, len(movies))
+	for i, movie := range movies {
+		ids[i] = movie.ID
+	}
+
+	images, err := app.models.Images.ForMovies(ids)
+	if err != nil {
+		return err
+	}
+
+	for _, movie := range movies {
+		movie.Images = images[movie.ID]
+		app.setImageURLs(movie.Images...)
+	}
+
+	return nil
+}
+
+func (app *app) setImageURLs(images ...*data.MovieImage) {
+	for _, image := range images {
+		for _, variant := range image.Variants {
+			variant.URL = app.images.URL(variant.Key)
+		}
+	}
+}
+
+// serveImageHandler serves images from the local image store. Image keys
+// are never reused, so clients and proxies may cache them for a year.
+func (app *app) serveImageHandler(store *images.LocalStore) func(c echo.Context) error {
+	return func(c echo.Context) error {
+		name, err := store.Path(c.Param("*"))
+		if err != nil {
+			return c.JSON(404, map[string]string{
+				"message": "records not found",
+			})
+		}
+
+		info, err := os.Stat(name)
+		if err != nil || info.IsDir() || strings.HasPrefix(info.Name(), ".") {
+			return c.JSON(404, map[string]string{
+				"message": "records not found",
+			})
+		}
+
+		c.Response().Header().Set("Cache-Control", "public, max-age=31536000, immutable")
+		c.Response().Header().Set("X-Content-Type-Options", "nosniff")
+		return c.File(name)
+	}
+}
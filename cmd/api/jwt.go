@@ -0,0 +1,83 @@
+package main
+
+import (
+	"errors"
+	"strconv"
+	"time"
+
+	"github.com/golang-jwt/jwt"
+	"github.com/mayank12gt/movie-webapp/internal/data"
+)
+
+const (
+	authModeToken = "token"
+	authModeJWT   = "jwt"
+
+	jwtIssuer = "movie-api"
+)
+
+// accessClaims are carried by a JWT access token. They hold everything the
+// authenticate and checkPermission middleware need, so that verifying a
+// request does not touch the database. Permissions are therefore only as
+// fresh as the token, at most -jwt-access-ttl old.
+type accessClaims struct {
+	Name        string           `json:"name"`
+	Email       string           `json:"email"`
+	Permissions data.Permissions `json:"permissions"`
+	Session     string           `json:"sid"`
+	jwt.StandardClaims
+}
+
+func (app *app) newAccessToken(user *data.User, permissions data.Permissions, family string) (string, time.Time, error) {
+	now := time.Now()
+	expiry := now.Add(app.config.auth.accessTTL)
+
+	if permissions == nil {
+		permissions = data.Permissions{}
+	}
+
+	claims := accessClaims{
+		Name:        user.Name,
+		Email:       user.Email,
+		Permissions: permissions,
+		Session:     family,
+		StandardClaims: jwt.StandardClaims{
+			Subject:   strconv.FormatInt(user.ID, 10),
+			Issuer:    jwtIssuer,
+			IssuedAt:  now.Unix(),
+			NotBefore: now.Unix(),
+			ExpiresAt: expiry.Unix(),
+		},
+	}
+
+	signed, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString([]byte(app.config.auth.jwtSecret))
+	if err != nil {
+		return "", time.Time{}, err
+	}
+
+	return signed, expiry, nil
+}
+
+func (app *app) parseAccessToken(tokenString string) (*accessClaims, error) {
+	var claims accessClaims
+
+	_, err := jwt.ParseWithClaims(tokenString, &claims, func(token *jwt.Token) (interface{}, error) {
+		if token.Method != jwt.SigningMethodHS256 {
+			return nil, errors.New("unexpected signing method")
+		}
+		return []byte(app.config.auth.jwtSecret), nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// StandardClaims.Valid treats a missing exp as valid, so check it here.
+	if claims.ExpiresAt == 0 || !claims.VerifyIssuer(jwtIssuer, true) {
+		return nil, errors.New("invalid claims")
+	}
+	if _, err := strconv.ParseInt(claims.Subject, 10, 64); err != nil {
+		return nil, errors.New("invalid subject")
+	}
+
+	return &claims, nil
+}
@@ -0,0 +1,484 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/go-playground/validator/v10"
+	"github.com/labstack/echo/v4"
+	"github.com/mayank12gt/movie-webapp/internal/data"
+)
+
+var listSortSafelist = []string{"updated", "newest", "name"}
+
+func (app *app) createListHandler() func(c echo.Context) error {
+	return func(c echo.Context) error {
+		var input struct {
+			Name        string `json:"name"`
+			Description string `json:"description"`
+			Public      bool   `json:"public"`
+		}
+
+		if err := c.Bind(&input); err != nil {
+			return c.JSON(http.StatusBadRequest, map[string]string{
+				"message": "Bad Request, verify the json body",
+			})
+		}
+
+		user := c.Get("user").(*data.User)
+
+		list := &data.List{
+			UserID:      user.ID,
+			UserName:    user.Name,
+			Name:        strings.TrimSpace(input.Name),
+			Description: strings.TrimSpace(input.Description),
+			Public:      input.Public,
+		}
+
+		validate := validator.New()
+		if err := validate.Struct(list); err != nil {
+			return c.JSON(422, map[string]string{
+				"message": err.Error(),
+			})
+		}
+
+		var reasons []string
+		list.Status, reasons = app.screen(list.Name, list.Description)
+
+		if err := app.models.Lists.Insert(list, reasons); err != nil {
+			return c.JSON(500, map[string]string{
+				"message": "Internal Server Error",
+			})
+		}
+
+		return c.JSON(201, list)
+	}
+}
+
+// getListHandler shows a list with a page of its entries. Private lists are
+// only shown to their owner.
+func (app *app) getListHandler() func(c echo.Context) error {
+	return func(c echo.Context) error {
+		listID, err := readIDParam(c, "id")
+		if err != nil {
+			return c.JSON(http.StatusBadRequest, map[string]string{
+				"message": err.Error(),
+			})
+		}
+
+		filters, err := readFilters(c, "position", []string{"position"})
+		if err != nil {
+			return c.JSON(http.StatusBadRequest, map[string]string{
+				"message": err.Error(),
+			})
+		}
+
+		validate := validator.New()
+		if err := validate.Struct(filters); err != nil {
+			return c.JSON(422, map[string]string{
+				"message": err.Error(),
+			})
+		}
+		if !filters.ValidSort() {
+			return c.JSON(422, map[string]string{
+				"message": "sort must be position",
+			})
+		}
+
+		list, err := app.models.Lists.Get(listID, currentUserID(c))
+		if err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				return c.JSON(404, map[string]string{
+					"message": "records not found",
+				})
+			}
+			return c.JSON(500, map[string]string{
+				"message": "Internal Server Error",
+			})
+		}
+
+		entries, meta, err := app.models.Lists.Entries(list.ID, filters)
+		if err != nil {
+			return c.JSON(500, map[string]string{
+				"message": "Internal Server Error",
+			})
+		}
+
+		movies := make([]*data.Movie, len(entries))
+		for i, entry := range entries {
+			movies[i] = entry.Movie
+		}
+		if err := app.loadImages(movies...); err != nil {
+			return c.JSON(500, map[string]string{
+				"message": "Internal Server Error",
+			})
+		}
+
+		return c.JSON(200, map[string]interface{}{
+			"list":     list,
+			"metadata": meta,
+			"entries":  entries,
+		})
+	}
+}
+
+// browseListsHandler pages through public lists, optionally of one ?user=.
+func (app *app) browseListsHandler() func(c echo.Context) error {
+	return func(c echo.Context) error {
+		var ownerID int64
+		if c.QueryParam("user") != "" {
+			var err error
+			ownerID, err = strconv.ParseInt(c.QueryParam("user"), 10, 64)
+			if err != nil || ownerID < 1 {
+				return c.JSON(http.StatusBadRequest, map[string]string{
+					"message": "user must be a positive integer",
+				})
+			}
+		}
+
+		return app.writeLists(c, ownerID, false)
+	}
+}
+
+func (app *app) listMyListsHandler() func(c echo.Context) error {
+	return func(c echo.Context) error {
+		user := c.Get("user").(*data.User)
+
+		return app.writeLists(c, user.ID, true)
+	}
+}
+
+func (app *app) writeLists(c echo.Context, ownerID int64, includePrivate bool) error {
+	filters, err := readFilters(c, "updated", listSortSafelist)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"message": err.Error(),
+		})
+	}
+
+	validate := validator.New()
+	if err := validate.Struct(filters); err != nil {
+		return c.JSON(422, map[string]string{
+			"message": err.Error(),
+		})
+	}
+	if !filters.ValidSort() {
+		return c.JSON(422, map[string]string{
+			"message": "sort must be one of " + strings.Join(listSortSafelist, " "),
+		})
+	}
+
+	lists, meta, err := app.models.Lists.List(ownerID, includePrivate, c.QueryParam("name"), filters)
+	if err != nil {
+		return c.JSON(500, map[string]string{
+			"message": "Internal Server Error",
+		})
+	}
+
+	return c.JSON(200, map[string]interface{}{
+		"metadata": meta,
+		"lists":    lists,
+	})
+}
+
+func (app *app) updateListHandler() func(c echo.Context) error {
+	return func(c echo.Context) error {
+		listID, err := readIDParam(c, "id")
+		if err != nil {
+			return c.JSON(http.StatusBadRequest, map[string]string{
+				"message": err.Error(),
+			})
+		}
+
+		var input struct {
+			Name        *string `json:"name"`
+			Description *string `json:"description"`
+			Public      *bool   `json:"public"`
+		}
+
+		if err := c.Bind(&input); err != nil {
+			return c.JSON(http.StatusBadRequest, map[string]string{
+				"message": "Bad Request, verify the json body",
+			})
+		}
+
+		user := c.Get("user").(*data.User)
+
+		list, err := app.models.Lists.Get(listID, user.ID)
+		if err != nil && !errors.Is(err, sql.ErrNoRows) {
+			return c.JSON(500, map[string]string{
+				"message": "Internal Server Error",
+			})
+		}
+		if err != nil || list.UserID != user.ID {
+			return c.JSON(404, map[string]string{
+				"message": "records not found",
+			})
+		}
+
+		if input.Name != nil {
+			list.Name = strings.TrimSpace(*input.Name)
+		}
+		if input.Description != nil {
+			list.Description = strings.TrimSpace(*input.Description)
+		}
+		if input.Public != nil {
+			list.Public = *input.Public
+		}
+
+		validate := validator.New()
+		if err := validate.Struct(list); err != nil {
+			return c.JSON(422, map[string]string{
+				"message": err.Error(),
+			})
+		}
+
+		var reasons []string
+		list.Status, reasons = app.screen(list.Name, list.Description)
+
+		if err := app.models.Lists.Update(list, reasons); err != nil {
+			if errors.Is(err, data.ErrRecordNotFound) {
+				return c.JSON(404, map[string]string{
+					"message": "records not found",
+				})
+			}
+			return c.JSON(500, map[string]string{
+				"message": "Internal Server Error",
+			})
+		}
+
+		return c.JSON(200, list)
+	}
+}
+
+func (app *app) deleteListHandler() func(c echo.Context) error {
+	return func(c echo.Context) error {
+		listID, err := readIDParam(c, "id")
+		if err != nil {
+			return c.JSON(http.StatusBadRequest, map[string]string{
+				"message": err.Error(),
+			})
+		}
+
+		user := c.Get("user").(*data.User)
+
+		if err := app.models.Lists.Delete(listID, user.ID); err != nil {
+			if errors.Is(err, data.ErrRecordNotFound) {
+				return c.JSON(404, map[string]string{
+					"message": "records not found",
+				})
+			}
+			return c.JSON(500, map[string]string{
+				"message": "Internal Server Error",
+			})
+		}
+
+		return c.JSON(200, map[string]string{
+			"message": "List deleted",
+		})
+	}
+}
+
+// addListEntryHandler adds a movie to one of the caller's lists, at the end
+// or at the given 1-based position.
+func (app *app) addListEntryHandler() func(c echo.Context) error {
+	return func(c echo.Context) error {
+		listID, err := readIDParam(c, "id")
+		if err != nil {
+			return c.JSON(http.StatusBadRequest, map[string]string{
+				"message": err.Error(),
+			})
+		}
+
+		var input struct {
+			MovieID  int64  `json:"movie_id" validate:"required,min=1"`
+			Position int    `json:"position" validate:"min=0"`
+			Note     string `json:"note" validate:"max=1000"`
+		}
+
+		if err := c.Bind(&input); err != nil {
+			return c.JSON(http.StatusBadRequest, map[string]string{
+				"message": "Bad Request, verify the json body",
+			})
+		}
+		input.Note = strings.TrimSpace(input.Note)
+
+		validate := validator.New()
+		if err := validate.Struct(input); err != nil {
+			return c.JSON(422, map[string]string{
+				"message": err.Error(),
+			})
+		}
+
+		user := c.Get("user").(*data.User)
+		entry := &data.ListEntry{
+			Position: input.Position,
+			Note:     input.Note,
+			Movie:    &data.Movie{ID: input.MovieID},
+		}
+		status, reasons := app.screen(entry.Note)
+
+		if err := app.models.Lists.AddEntry(listID, user.ID, status, reasons, entry); err != nil {
+			switch {
+			case errors.Is(err, data.ErrDuplicateEntry):
+				return c.JSON(http.StatusConflict, map[string]string{
+					"message": err.Error(),
+				})
+			case errors.Is(err, data.ErrRecordNotFound):
+				return c.JSON(404, map[string]string{
+					"message": "records not found",
+				})
+			default:
+				return c.JSON(500, map[string]string{
+					"message": "Internal Server Error",
+				})
+			}
+		}
+
+		return c.JSON(201, entry)
+	}
+}
+
+func (app *app) updateListEntryHandler() func(c echo.Context) error {
+	return func(c echo.Context) error {
+		listID, err := readIDParam(c, "id")
+		if err != nil {
+			return c.JSON(http.StatusBadRequest, map[string]string{
+				"message": err.Error(),
+			})
+		}
+		movieID, err := readIDParam(c, "movie_id")
+		if err != nil {
+			return c.JSON(http.StatusBadRequest, map[string]string{
+				"message": err.Error(),
+			})
+		}
+
+		var input struct {
+			Note string `json:"note"`
+		}
+
+		if err := c.Bind(&input); err != nil {
+			return c.JSON(http.StatusBadRequest, map[string]string{
+				"message": "Bad Request, verify the json body",
+			})
+		}
+
+		user := c.Get("user").(*data.User)
+		entry := &data.ListEntry{
+			Note:  strings.TrimSpace(input.Note),
+			Movie: &data.Movie{ID: movieID},
+		}
+
+		validate := validator.New()
+		if err := validate.Struct(entry); err != nil {
+			return c.JSON(422, map[string]string{
+				"message": err.Error(),
+			})
+		}
+
+		status, reasons := app.screen(entry.Note)
+
+		if err := app.models.Lists.UpdateEntryNote(listID, user.ID, status, reasons, entry); err != nil {
+			if errors.Is(err, data.ErrRecordNotFound) {
+				return c.JSON(404, map[string]string{
+					"message": "records not found",
+				})
+			}
+			return c.JSON(500, map[string]string{
+				"message": "Internal Server Error",
+			})
+		}
+
+		return c.JSON(200, entry)
+	}
+}
+
+func (app *app) deleteListEntryHandler() func(c echo.Context) error {
+	return func(c echo.Context) error {
+		listID, err := readIDParam(c, "id")
+		if err != nil {
+			return c.JSON(http.StatusBadRequest, map[string]string{
+				"message": err.Error(),
+			})
+		}
+		movieID, err := readIDParam(c, "movie_id")
+		if err != nil {
+			return c.JSON(http.StatusBadRequest, map[string]string{
+				"message": err.Error(),
+			})
+		}
+
+		user := c.Get("user").(*data.User)
+
+		if err := app.models.Lists.RemoveEntry(listID, user.ID, movieID); err != nil {
+			if errors.Is(err, data.ErrRecordNotFound) {
+				return c.JSON(404, map[string]string{
+					"message": "records not found",
+				})
+			}
+			return c.JSON(500, map[string]string{
+				"message": "Internal Server Error",
+			})
+		}
+
+		return c.JSON(200, map[string]string{
+			"message": "Removed from list",
+		})
+	}
+}
+
+// reorderListHandler takes the full new order of a list as movie ids.
+func (app *app) reorderListHandler() func(c echo.Context) error {
+	return func(c echo.Context) error {
+		listID, err := readIDParam(c, "id")
+		if err != nil {
+			return c.JSON(http.StatusBadRequest, map[string]string{
+				"message": err.Error(),
+			})
+		}
+
+		var input struct {
+			MovieIDs []int64 `json:"movie_ids" validate:"unique,dive,min=1"`
+		}
+
+		if err := c.Bind(&input); err != nil {
+			return c.JSON(http.StatusBadRequest, map[string]string{
+				"message": "Bad Request, verify the json body",
+			})
+		}
+
+		validate := validator.New()
+		if err := validate.Struct(input); err != nil {
+			return c.JSON(422, map[string]string{
+				"message": err.Error(),
+			})
+		}
+
+		user := c.Get("user").(*data.User)
+
+		if err := app.models.Lists.Reorder(listID, user.ID, input.MovieIDs); err != nil {
+			switch {
+			case errors.Is(err, data.ErrListOrderMismatch):
+				return c.JSON(422, map[string]string{
+					"message": err.Error(),
+				})
+			case errors.Is(err, data.ErrRecordNotFound):
+				return c.JSON(404, map[string]string{
+					"message": "records not found",
+				})
+			default:
+				return c.JSON(500, map[string]string{
+					"message": "Internal Server Error",
+				})
+			}
+		}
+
+		return c.JSON(200, map[string]string{
+			"message": "List reordered",
+		})
+	}
+}
@@ -3,16 +3,25 @@ package main
 import (
 	"context"
 	"database/sql"
+	"encoding/hex"
+	"errors"
 	"flag"
+	"fmt"
 	"log"
+	"net"
 	"os"
+	"strings"
 	"sync"
 	"time"
 
 	"github.com/joho/godotenv"
 	_ "github.com/lib/pq"
 	"github.com/mayank12gt/movie-webapp/internal/data"
+	"github.com/mayank12gt/movie-webapp/internal/images"
 	"github.com/mayank12gt/movie-webapp/internal/mailer"
+	"github.com/mayank12gt/movie-webapp/internal/moderation"
+	"github.com/mayank12gt/movie-webapp/internal/oidc"
+	"github.com/mayank12gt/movie-webapp/internal/vault"
 )
 
 const version = "1.0.0"
@@ -20,7 +29,11 @@ const version = "1.0.0"
 type config struct {
 	port int
 	env  string
-	db   struct {
+	// trustedProxies are the networks of reverse proxies whose
+	// X-Forwarded-For header is believed. Without any, the client IP is
+	// the address of the connection.
+	trustedProxies []*net.IPNet
+	db             struct {
 		dsn string
 	}
 
@@ -31,14 +44,63 @@ type config struct {
 		password string
 		sender   string
 	}
+
+	auth struct {
+		mode       string
+		jwtSecret  string
+		accessTTL  time.Duration
+		refreshTTL time.Duration
+	}
+
+	mfa struct {
+		key string
+	}
+
+	oidc struct {
+		issuer       string
+		clientID     string
+		clientSecret string
+		redirectURL  string
+	}
+
+	ratings struct {
+		scale    data.RatingScale
+		minVotes int
+		mean     float64
+	}
+
+	recommendations struct {
+		interval   time.Duration
+		minOverlap int
+		neighbours int
+	}
+
+	trending struct {
+		flushInterval time.Duration
+	}
+
+	images struct {
+		dir string
+		url string
+	}
+
+	moderation struct {
+		bannedWords     string
+		bannedWordsFile string
+	}
 }
 
 type app struct {
-	config config
-	logger *log.Logger
-	models data.Models
-	mailer mailer.Mailer
-	wg     sync.WaitGroup
+	config   config
+	logger   *log.Logger
+	models   data.Models
+	mailer   mailer.Mailer
+	vault    *vault.Vault
+	oidc     *oidc.Provider
+	filter   moderation.Filter
+	trending *trendingCounter
+	images   images.Store
+	wg       sync.WaitGroup
 }
 
 func main() {
@@ -51,16 +113,112 @@ func main() {
 
 	flag.StringVar(&cfg.db.dsn, "db-dsn", "", "PostgreSQL DSN")
 
+	flag.Func("trusted-proxies", "Comma separated CIDRs of reverse proxies trusted to set X-Forwarded-For", func(value string) error {
+		for _, cidr := range strings.Split(value, ",") {
+			_, network, err := net.ParseCIDR(strings.TrimSpace(cidr))
+			if err != nil {
+				return err
+			}
+			cfg.trustedProxies = append(cfg.trustedProxies, network)
+		}
+		return nil
+	})
+
 	flag.StringVar(&cfg.smtp.host, "smtp-host", "live.smtp.mailtrap.io", "SMTP host")
 	flag.IntVar(&cfg.smtp.port, "smtp-port", 587, "SMTP port")
 	flag.StringVar(&cfg.smtp.username, "smtp-username", "api", "SMTP username")
 	flag.StringVar(&cfg.smtp.password, "smtp-password", "c98a736044ed50a9213cd04722fa6e17", "SMTP password")
 	flag.StringVar(&cfg.smtp.sender, "smtp-sender", "MoviesDB <movies@mayankgupta.site>", "SMTP sender")
 
+	flag.StringVar(&cfg.auth.mode, "auth-mode", authModeToken, "Authentication mode(token|jwt)")
+	flag.StringVar(&cfg.auth.jwtSecret, "jwt-secret", os.Getenv("JWT_SECRET"), "Secret used to sign JWT access tokens")
+	flag.DurationVar(&cfg.auth.accessTTL, "jwt-access-ttl", 15*time.Minute, "Lifetime of JWT access tokens")
+	flag.DurationVar(&cfg.auth.refreshTTL, "jwt-refresh-ttl", 7*24*time.Hour, "Lifetime of refresh tokens")
+
+	flag.StringVar(&cfg.mfa.key, "mfa-key", os.Getenv("MFA_KEY"), "Hex encoded 32 byte key encrypting TOTP secrets at rest")
+
+	flag.StringVar(&cfg.oidc.issuer, "oidc-issuer", os.Getenv("OIDC_ISSUER"), "OpenID Connect issuer URL, enables social login")
+	flag.StringVar(&cfg.oidc.clientID, "oidc-client-id", os.Getenv("OIDC_CLIENT_ID"), "OpenID Connect client id")
+	flag.StringVar(&cfg.oidc.clientSecret, "oidc-client-secret", os.Getenv("OIDC_CLIENT_SECRET"), "OpenID Connect client secret")
+	flag.StringVar(&cfg.oidc.redirectURL, "oidc-redirect-url", "http://localhost:3000/users/oidc/callback", "OpenID Connect redirect URL")
+
+	flag.Float64Var(&cfg.ratings.scale.Min, "rating-min", data.DefaultRatingScale.Min, "Lowest rating")
+	flag.Float64Var(&cfg.ratings.scale.Max, "rating-max", data.DefaultRatingScale.Max, "Highest rating")
+	flag.Float64Var(&cfg.ratings.scale.Step, "rating-step", data.DefaultRatingScale.Step, "Rating increment, 0.5 allows half stars")
+	flag.IntVar(&cfg.ratings.minVotes, "rating-min-votes", data.DefaultRatingWeighting.MinVotes, "Ratings a movie needs before its weighted rating relies mostly on its own average")
+	flag.Float64Var(&cfg.ratings.mean, "rating-mean", 0, "Rating assumed for movies with few ratings (default the mean of all ratings)")
+
+	flag.DurationVar(&cfg.recommendations.interval, "similarity-interval", time.Hour, "How often movie similarities are recomputed for recommendations, 0 disables")
+	flag.IntVar(&cfg.recommendations.minOverlap, "similarity-min-overlap", data.DefaultSimilarityMinOverlap, "Users who must have rated both movies before they are considered similar")
+	flag.IntVar(&cfg.recommendations.neighbours, "similarity-neighbours", data.DefaultSimilarityNeighbours, "Most similar movies kept per movie")
+
+	flag.DurationVar(&cfg.trending.flushInterval, "trending-flush-interval", 30*time.Second, "How often movie activity counted in memory is written to the database")
+
+	flag.StringVar(&cfg.images.dir, "images-dir", "uploads", "Directory uploaded movie images are stored in")
+	flag.StringVar(&cfg.images.url, "images-url", "/images", "URL uploaded movie images are served from, a path on this server or a CDN in front of it")
+
+	flag.StringVar(&cfg.moderation.bannedWords, "banned-words", os.Getenv("BANNED_WORDS"), "Comma separated words that hold submissions for moderation")
+	flag.StringVar(&cfg.moderation.bannedWordsFile, "banned-words-file", "", "File of words, one per line, that hold submissions for moderation")
+
+	var grantAdmin string
+	flag.StringVar(&grantAdmin, "grant-admin", "", "Grant permissions:admin to the user with this email and exit")
+
+	var repairRatings bool
+	flag.BoolVar(&repairRatings, "repair-ratings", false, "Recompute every movie's rating aggregates from the ratings table and exit")
+
 	logger := log.New(os.Stdout, "", log.Ldate|log.Ltime)
 
 	flag.Parse()
 
+	switch cfg.auth.mode {
+	case authModeToken:
+	case authModeJWT:
+		if len(cfg.auth.jwtSecret) < 32 {
+			logger.Fatal("-jwt-secret must be at least 32 characters when -auth-mode=jwt")
+		}
+	default:
+		logger.Fatalf("unknown -auth-mode %q", cfg.auth.mode)
+	}
+
+	if err := cfg.ratings.scale.Check(); err != nil {
+		logger.Fatal(err)
+	}
+	if cfg.ratings.minVotes < 1 {
+		logger.Fatal("-rating-min-votes must be at least 1")
+	}
+	if cfg.recommendations.minOverlap < 1 || cfg.recommendations.neighbours < 1 {
+		logger.Fatal("-similarity-min-overlap and -similarity-neighbours must be at least 1")
+	}
+	if cfg.trending.flushInterval <= 0 {
+		logger.Fatal("-trending-flush-interval must be positive")
+	}
+
+	var secretVault *vault.Vault
+	if cfg.mfa.key != "" {
+		key, err := hex.DecodeString(cfg.mfa.key)
+		if err != nil {
+			logger.Fatal("-mfa-key must be hex encoded")
+		}
+		secretVault, err = vault.New(key)
+		if err != nil {
+			logger.Fatal(err)
+		}
+	}
+
+	filter := moderation.Chain{moderation.NewWordFilter(strings.Split(cfg.moderation.bannedWords, ","))}
+	if cfg.moderation.bannedWordsFile != "" {
+		words, err := moderation.LoadWordFilter(cfg.moderation.bannedWordsFile)
+		if err != nil {
+			logger.Fatal(err)
+		}
+		filter = append(filter, words)
+	}
+
+	imageStore, err := images.NewLocalStore(cfg.images.dir, cfg.images.url)
+	if err != nil {
+		logger.Fatal(err)
+	}
+
 	db, err := openDB(cfg)
 	if err != nil {
 		logger.Fatal(err)
@@ -68,11 +226,43 @@ func main() {
 	defer db.Close()
 	logger.Printf("DB connected")
 
+	models := data.NewModels(db)
+	weighting := data.RatingWeighting{MinVotes: cfg.ratings.minVotes, Mean: cfg.ratings.mean}
+	models.Movies.Weighting = weighting
+	models.Watchlist.Weighting = weighting
+	models.Recommendations.MinOverlap = cfg.recommendations.minOverlap
+	models.Recommendations.Neighbours = cfg.recommendations.neighbours
+
 	app := &app{
-		config: cfg,
-		logger: logger,
-		models: data.NewModels(db),
-		mailer: mailer.New(cfg.smtp.host, cfg.smtp.port, cfg.smtp.username, cfg.smtp.password, cfg.smtp.sender),
+		config:   cfg,
+		logger:   logger,
+		models:   models,
+		mailer:   mailer.New(cfg.smtp.host, cfg.smtp.port, cfg.smtp.username, cfg.smtp.password, cfg.smtp.sender),
+		vault:    secretVault,
+		filter:   filter,
+		trending: newTrendingCounter(),
+		images:   imageStore,
+	}
+
+	if cfg.oidc.issuer != "" {
+		app.oidc = oidc.New(cfg.oidc.issuer, cfg.oidc.clientID, cfg.oidc.clientSecret, cfg.oidc.redirectURL)
+	}
+
+	if grantAdmin != "" {
+		if err := app.grantAdmin(grantAdmin); err != nil {
+			logger.Fatal(err)
+		}
+		logger.Printf("granted permissions:admin to %s", grantAdmin)
+		return
+	}
+
+	if repairRatings {
+		repaired, err := app.models.Ratings.RepairAggregates()
+		if err != nil {
+			logger.Fatal(err)
+		}
+		logger.Printf("repaired rating aggregates of %d movies", repaired)
+		return
 	}
 
 	// server := &http.Server{
@@ -101,6 +291,20 @@ func main() {
 
 }
 
+// grantAdmin bootstraps the first administrator, who can then manage every
+// other user's permissions through the /users/:id/permissions endpoints.
+func (app *app) grantAdmin(email string) error {
+	user, err := app.models.Users.GetByEmail(email)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return fmt.Errorf("no user with email %s", email)
+		}
+		return err
+	}
+
+	return app.models.Permissions.AddForUser(user.ID, "permissions:admin")
+}
+
 func openDB(cfg config) (*sql.DB, error) {
 	db, err := sql.Open("postgres", cfg.db.dsn)
 	if err != nil {
@@ -0,0 +1,407 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/go-playground/validator/v10"
+	"github.com/labstack/echo/v4"
+	"github.com/mayank12gt/movie-webapp/internal/data"
+	"github.com/mayank12gt/movie-webapp/internal/totp"
+)
+
+const (
+	mfaIssuer         = "MovieDB"
+	mfaPendingTTL     = 5 * time.Minute
+	mfaMaxFailedCodes = 5
+)
+
+func (app *app) beginMFAHandler() func(c echo.Context) error {
+	return func(c echo.Context) error {
+
+		if app.vault == nil {
+			return c.JSON(http.StatusServiceUnavailable, map[string]string{
+				"message": "two-factor authentication is not configured on this server",
+			})
+		}
+
+		user := c.Get("user").(*data.User)
+
+		secret, err := totp.GenerateSecret()
+		if err != nil {
+			return c.JSON(500, map[string]string{
+				"message": "Internal Server Error",
+			})
+		}
+
+		encrypted, err := app.vault.Encrypt([]byte(secret))
+		if err != nil {
+			return c.JSON(500, map[string]string{
+				"message": "Internal Server Error",
+			})
+		}
+
+		if err := app.models.MFA.Begin(user.ID, encrypted); err != nil {
+			if errors.Is(err, data.ErrRecordNotFound) {
+				return c.JSON(http.StatusConflict, map[string]string{
+					"message": "two-factor authentication is already enabled",
+				})
+			}
+			return c.JSON(500, map[string]string{
+				"message": "Internal Server Error",
+			})
+		}
+
+		return c.JSON(200, map[string]string{
+			"secret":           secret,
+			"provisioning_uri": totp.URI(mfaIssuer, user.Email, secret),
+		})
+	}
+}
+
+func (app *app) confirmMFAHandler() func(c echo.Context) error {
+	return func(c echo.Context) error {
+
+		var input struct {
+			Code string `json:"code" validate:"required,len=6,numeric"`
+		}
+
+		if err := c.Bind(&input); err != nil {
+			return c.JSON(http.StatusBadRequest, map[string]string{
+				"message": "Bad Request, verify json body",
+			})
+		}
+
+		validate := validator.New()
+		if err := validate.Struct(input); err != nil {
+			return c.JSON(422, map[string]string{
+				"message": err.Error(),
+			})
+		}
+
+		user := c.Get("user").(*data.User)
+
+		mfa, err := app.models.MFA.Get(user.ID)
+		if err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				return c.JSON(404, map[string]string{
+					"message": "no two-factor enrolment in progress",
+				})
+			}
+			return c.JSON(500, map[string]string{
+				"message": "Internal Server Error",
+			})
+		}
+		if mfa.Enabled {
+			return c.JSON(http.StatusConflict, map[string]string{
+				"message": "two-factor authentication is already enabled",
+			})
+		}
+
+		step, ok, err := app.checkTOTP(mfa, input.Code)
+		if err != nil {
+			return c.JSON(500, map[string]string{
+				"message": "Internal Server Error",
+			})
+		}
+		if !ok {
+			return c.JSON(422, map[string]string{
+				"message": "invalid code",
+			})
+		}
+
+		recoveryCodes, err := app.models.MFA.Enable(user.ID, step)
+		if err != nil {
+			return c.JSON(500, map[string]string{
+				"message": "Internal Server Error",
+			})
+		}
+
+		return c.JSON(200, map[string]interface{}{
+			"message":        "two-factor authentication enabled, store these recovery codes somewhere safe",
+			"recovery_codes": recoveryCodes,
+		})
+	}
+}
+
+// disableMFAHandler turns 2FA off given the account password and a code.
+// Attempts count towards the account's sign in lockout as well as the 2FA
+// attempt limit, so a stolen session can't be used to guess either.
+func (app *app) disableMFAHandler() func(c echo.Context) error {
+	return func(c echo.Context) error {
+
+		var input struct {
+			Password     string `json:"password" validate:"required"`
+			Code         string `json:"code"`
+			RecoveryCode string `json:"recovery_code"`
+		}
+
+		if err := c.Bind(&input); err != nil {
+			return c.JSON(http.StatusBadRequest, map[string]string{
+				"message": "Bad Request, verify json body",
+			})
+		}
+
+		validate := validator.New()
+		if err := validate.Struct(input); err != nil {
+			return c.JSON(422, map[string]string{
+				"message": err.Error(),
+			})
+		}
+
+		user := c.Get("user").(*data.User)
+
+		enabled, err := app.models.MFA.IsEnabled(user.ID)
+		if err != nil {
+			return c.JSON(500, map[string]string{
+				"message": "Internal Server Error",
+			})
+		}
+		if !enabled {
+			return c.JSON(404, map[string]string{
+				"message": "two-factor authentication is not enabled",
+			})
+		}
+
+		account, err := app.models.Throttles.Claim(emailThrottleKey(user.Email), accountLockoutThreshold, lockoutBase, lockoutMax)
+		if err != nil {
+			return c.JSON(500, map[string]string{
+				"message": "Internal Server Error",
+			})
+		}
+		if account.Refused {
+			return tooManyAttempts(c, account.LockedUntil, "too many failed attempts, try again later")
+		}
+
+		_, ok, err := app.models.MFA.ClaimAttempt(user.ID, mfaMaxFailedCodes)
+		if err != nil {
+			return c.JSON(500, map[string]string{
+				"message": "Internal Server Error",
+			})
+		}
+		if !ok {
+			if err := app.models.Throttles.Release(account); err != nil {
+				return c.JSON(500, map[string]string{
+					"message": "Internal Server Error",
+				})
+			}
+			return c.JSON(http.StatusTooManyRequests, map[string]string{
+				"message": "too many invalid codes, sign in again before retrying",
+			})
+		}
+
+		match, err := user.Password.Compare(input.Password)
+		if err != nil {
+			return c.JSON(500, map[string]string{
+				"message": "Internal Server Error",
+			})
+		}
+		if !match {
+			app.notifyLockout(c, user, account)
+			return c.JSON(422, map[string]string{
+				"message": "invalid password",
+			})
+		}
+
+		ok, err = app.verifyMFA(user.ID, input.Code, input.RecoveryCode)
+		if err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				return c.JSON(404, map[string]string{
+					"message": "two-factor authentication is not enabled",
+				})
+			}
+			return c.JSON(500, map[string]string{
+				"message": "Internal Server Error",
+			})
+		}
+		if !ok {
+			app.notifyLockout(c, user, account)
+			return c.JSON(422, map[string]string{
+				"message": "invalid code",
+			})
+		}
+
+		if err := app.models.MFA.Delete(user.ID); err != nil {
+			return c.JSON(500, map[string]string{
+				"message": "Internal Server Error",
+			})
+		}
+		if err := app.models.Throttles.Reset(emailThrottleKey(user.Email)); err != nil {
+			return c.JSON(500, map[string]string{
+				"message": "Internal Server Error",
+			})
+		}
+
+		return c.JSON(200, map[string]string{
+			"message": "two-factor authentication disabled",
+		})
+	}
+}
+
+// verifyMFAHandler completes a sign in started by createAuthenticationTokenHandler
+// for a user with 2FA, exchanging the "mfa pending" token and a code for a
+// real session. Wrong codes count towards the account's sign in lockout,
+// which the password alone doesn't reset, and after mfaMaxFailedCodes of
+// them the user has to sign in again.
+func (app *app) verifyMFAHandler() func(c echo.Context) error {
+	return func(c echo.Context) error {
+
+		var input struct {
+			MFAToken     string `json:"mfa_token" validate:"required,len=26"`
+			Code         string `json:"code"`
+			RecoveryCode string `json:"recovery_code"`
+		}
+
+		if err := c.Bind(&input); err != nil {
+			return c.JSON(http.StatusBadRequest, map[string]string{
+				"message": "Bad Request, verify json body",
+			})
+		}
+
+		validate := validator.New()
+		if err := validate.Struct(input); err != nil {
+			return c.JSON(422, map[string]string{
+				"message": err.Error(),
+			})
+		}
+
+		user, err := app.models.Users.GetForToken(data.ScopeMFAPending, input.MFAToken)
+		if err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				return c.JSON(http.StatusUnauthorized, map[string]string{
+					"message": "invalid or expired mfa token, please sign in again",
+				})
+			}
+			return c.JSON(500, map[string]string{
+				"message": "Internal Server Error",
+			})
+		}
+
+		emailKey := emailThrottleKey(user.Email)
+
+		account, err := app.models.Throttles.Claim(emailKey, accountLockoutThreshold, lockoutBase, lockoutMax)
+		if err != nil {
+			return c.JSON(500, map[string]string{
+				"message": "Internal Server Error",
+			})
+		}
+		if account.Refused {
+			return tooManyAttempts(c, account.LockedUntil, "too many failed sign in attempts, try again later")
+		}
+
+		attempts, ok, err := app.models.MFA.ClaimAttempt(user.ID, mfaMaxFailedCodes)
+		if err != nil {
+			return c.JSON(500, map[string]string{
+				"message": "Internal Server Error",
+			})
+		}
+		if !ok {
+			return app.restartSignIn(c, user, account, "too many invalid codes, please sign in again")
+		}
+
+		ok, err = app.verifyMFA(user.ID, input.Code, input.RecoveryCode)
+		if err != nil {
+			// 2FA was turned off after the token was issued.
+			if errors.Is(err, sql.ErrNoRows) {
+				return app.restartSignIn(c, user, account, "invalid or expired mfa token, please sign in again")
+			}
+			return c.JSON(500, map[string]string{
+				"message": "Internal Server Error",
+			})
+		}
+
+		if !ok {
+			app.notifyLockout(c, user, account)
+			if attempts >= mfaMaxFailedCodes {
+				if err := app.models.Tokens.DeleteAllForUser(user.ID, data.ScopeMFAPending); err != nil {
+					return c.JSON(500, map[string]string{
+						"message": "Internal Server Error",
+					})
+				}
+				return c.JSON(http.StatusUnauthorized, map[string]string{
+					"message": "too many invalid codes, please sign in again",
+				})
+			}
+			return c.JSON(http.StatusUnauthorized, map[string]string{
+				"message": "invalid code",
+			})
+		}
+
+		if err := app.models.Tokens.DeleteAllForUser(user.ID, data.ScopeMFAPending); err != nil {
+			return c.JSON(500, map[string]string{
+				"message": "Internal Server Error",
+			})
+		}
+		if err := app.models.Throttles.Reset(emailKey); err != nil {
+			return c.JSON(500, map[string]string{
+				"message": "Internal Server Error",
+			})
+		}
+
+		return app.startSession(c, user)
+	}
+}
+
+// restartSignIn gives up on an "mfa pending" token without checking a code,
+// forgiving the attempt claimed for it.
+func (app *app) restartSignIn(c echo.Context, user *data.User, account *data.ThrottleClaim, message string) error {
+
+	if err := app.models.Throttles.Release(account); err != nil {
+		return c.JSON(500, map[string]string{
+			"message": "Internal Server Error",
+		})
+	}
+	if err := app.models.Tokens.DeleteAllForUser(user.ID, data.ScopeMFAPending); err != nil {
+		return c.JSON(500, map[string]string{
+			"message": "Internal Server Error",
+		})
+	}
+
+	return c.JSON(http.StatusUnauthorized, map[string]string{
+		"message": message,
+	})
+}
+
+// verifyMFA checks either a TOTP code or a one-time recovery code for a
+// user with 2FA enabled. It returns sql.ErrNoRows if 2FA isn't enabled.
+func (app *app) verifyMFA(userID int64, code, recoveryCode string) (bool, error) {
+
+	mfa, err := app.models.MFA.Get(userID)
+	if err != nil {
+		return false, err
+	}
+	if !mfa.Enabled {
+		return false, sql.ErrNoRows
+	}
+
+	if recoveryCode != "" {
+		return app.models.MFA.UseRecoveryCode(userID, strings.ToUpper(strings.TrimSpace(recoveryCode)))
+	}
+
+	step, ok, err := app.checkTOTP(mfa, code)
+	if err != nil || !ok {
+		return false, err
+	}
+
+	// Each code is accepted once, so one seen over the shoulder or in a
+	// replayed request is useless.
+	return app.models.MFA.UseStep(userID, step)
+}
+
+func (app *app) checkTOTP(mfa *data.MFA, code string) (int64, bool, error) {
+	if app.vault == nil {
+		return 0, false, errors.New("two-factor authentication is not configured")
+	}
+
+	secret, err := app.vault.Decrypt(mfa.Secret)
+	if err != nil {
+		return 0, false, err
+	}
+
+	step, ok := totp.Validate(string(secret), code, time.Now(), 1)
+
+	return step, ok, nil
+}
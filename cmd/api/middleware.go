@@ -1,42 +1,221 @@
 package main
 
 import (
+	"database/sql"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
 	"github.com/labstack/echo/v4"
 	"github.com/mayank12gt/movie-webapp/internal/data"
 )
 
+// authFailure describes why a request could not be authenticated, as the
+// WWW-Authenticate challenge and message of the 401 response.
+type authFailure struct {
+	challenge string
+	message   string
+}
+
+// authenticate accepts an authentication token either as an
+// "Authorization: Bearer <token>" header or as the "token" cookie. When the
+// header is present it always wins and the cookie is ignored, so a client
+// can't be authenticated as one user by its header and another by its cookie.
+// With -auth-mode=jwt, a JWT access token is verified without touching the
+// database; opaque tokens such as API keys are still looked up.
+//
+// An API key is limited to its permissions, which only checkPermission
+// enforces. Routes guarded by authenticate alone write a user's own content,
+// so API keys may only read through them.
 func (app *app) authenticate(next echo.HandlerFunc) echo.HandlerFunc {
+	return app.authenticateAny(func(c echo.Context) error {
+		session := c.Get("session").(*data.Token)
+
+		if session.Scope == data.ScopeAPIKey {
+			switch c.Request().Method {
+			case http.MethodGet, http.MethodHead, http.MethodOptions:
+			default:
+				return c.JSON(http.StatusForbidden, map[string]string{
+					"message": "this endpoint cannot be used with an API key",
+				})
+			}
+		}
+
+		return next(c)
+	})
+}
+
+// authenticateAny is authenticate without the API key restriction, for
+// checkPermission, which enforces a key's permissions itself.
+func (app *app) authenticateAny(next echo.HandlerFunc) echo.HandlerFunc {
 	return func(c echo.Context) error {
 
 		app.logger.Print("auth middleware")
-		authToken, err := c.Cookie("token")
 
+		failure, err := app.identify(c)
 		if err != nil {
-			return c.JSON(400, map[string]string{
-				"error": "user is not authenticated",
+			return c.JSON(500, map[string]string{
+				"message": "Internal server error",
+			})
+		}
+		if failure != nil {
+			c.Response().Header().Set("WWW-Authenticate", failure.challenge)
+			return c.JSON(http.StatusUnauthorized, map[string]string{
+				"message": failure.message,
 			})
 		}
 
-		user, err := app.models.Users.GetForToken(data.ScopeAuthentication, authToken.Value)
-		if err != nil {
+		return next(c)
+
+	}
+}
+
+// authenticateOptional is authenticate for public endpoints that show more
+// to signed in users. Requests without credentials go through anonymously,
+// and so do requests whose token cookie is stale, while a bad Authorization
+// header is still rejected because the client explicitly asked for it.
+func (app *app) authenticateOptional(next echo.HandlerFunc) echo.HandlerFunc {
+	strict := app.authenticate(next)
+
+	return func(c echo.Context) error {
+		if c.Request().Header.Get("Authorization") != "" {
+			return strict(c)
+		}
+		if _, err := c.Cookie("token"); err != nil {
+			return next(c)
+		}
+
+		// identify only fills in the context on success, so a failure
+		// leaves the request anonymous.
+		if _, err := app.identify(c); err != nil {
 			return c.JSON(500, map[string]string{
 				"message": "Internal server error",
 			})
 		}
 
-		c.Set("user", user)
-
 		return next(c)
+	}
+}
+
+// identify reads the request's credentials and, when they are valid, stores
+// the user and session in the context.
+func (app *app) identify(c echo.Context) (*authFailure, error) {
+
+	c.Response().Header().Add("Vary", "Authorization")
+
+	var plaintext string
 
+	if header := c.Request().Header.Get("Authorization"); header != "" {
+		scheme, value, found := strings.Cut(header, " ")
+		if !found || !strings.EqualFold(scheme, "Bearer") || !(validTokenFormat(value) || app.isJWT(value)) {
+			return &authFailure{`Bearer error="invalid_request", error_description="malformed Authorization header"`,
+				"malformed Authorization header, expected 'Bearer <token>'"}, nil
+		}
+		plaintext = value
+	} else {
+		authToken, err := c.Cookie("token")
+		if err != nil {
+			return &authFailure{"Bearer", "user is not authenticated"}, nil
+		}
+		plaintext = authToken.Value
 	}
+
+	invalidToken := &authFailure{`Bearer error="invalid_token", error_description="token is invalid or expired"`,
+		"invalid or expired authentication token"}
+
+	if app.isJWT(plaintext) {
+		claims, err := app.parseAccessToken(plaintext)
+		if err != nil {
+			return invalidToken, nil
+		}
+
+		userID, _ := strconv.ParseInt(claims.Subject, 10, 64)
+
+		c.Set("user", &data.User{
+			ID:        userID,
+			Name:      claims.Name,
+			Email:     claims.Email,
+			Activated: true,
+		})
+		c.Set("session", &data.Token{
+			UserId: userID,
+			Scope:  data.ScopeAuthentication,
+			Family: claims.Session,
+			Expiry: time.Unix(claims.ExpiresAt, 0),
+		})
+		c.Set("permissions", claims.Permissions)
+
+		return nil, nil
+	}
+
+	user, session, err := app.models.Users.GetWithToken(plaintext, data.ScopeAuthentication, data.ScopeAPIKey)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return invalidToken, nil
+		}
+		return nil, err
+	}
+
+	app.background(func() {
+		if err := app.models.Tokens.Touch(session.ID); err != nil {
+			app.logger.Print(err)
+		}
+	})
+
+	c.Set("user", user)
+	c.Set("session", session)
+
+	return nil, nil
+}
+
+// currentUserID returns the id of the signed in user, or 0 for anonymous
+// requests let through by authenticateOptional.
+func currentUserID(c echo.Context) int64 {
+	if user, ok := c.Get("user").(*data.User); ok && user != nil {
+		return user.ID
+	}
+	return 0
+}
+
+func (app *app) isJWT(token string) bool {
+	return app.config.auth.mode == authModeJWT && strings.Count(token, ".") == 2
+}
+
+// validTokenFormat checks the shape of a plaintext token as produced by
+// data.generateToken before it is looked up.
+func validTokenFormat(token string) bool {
+	if len(token) != 26 {
+		return false
+	}
+	for _, r := range token {
+		if !(r >= 'A' && r <= 'Z') && !(r >= '2' && r <= '7') {
+			return false
+		}
+	}
+	return true
 }
 
 func (app *app) checkPermission(code string, next echo.HandlerFunc) echo.HandlerFunc {
 	fn := func(c echo.Context) error {
 		app.logger.Print("PermissionsMiddleware")
 		user := c.Get("user").(*data.User)
+		session := c.Get("session").(*data.Token)
 
-		permissions, err := app.models.Permissions.GetAllForUser(user.ID)
+		// JWT access tokens carry their own permissions, and an API key is
+		// limited to the permissions it was issued with rather than
+		// everything its owner is allowed to do.
+		var permissions data.Permissions
+		var err error
+		switch {
+		case c.Get("permissions") != nil:
+			permissions = c.Get("permissions").(data.Permissions)
+		case session.Scope == data.ScopeAPIKey:
+			permissions, err = app.models.Permissions.GetAllForToken(session.ID)
+		default:
+			permissions, err = app.models.Permissions.GetAllForUser(user.ID)
+		}
 		if err != nil {
 			return c.JSON(500, map[string]string{
 				"message": "internal server error",
@@ -51,6 +230,24 @@ func (app *app) checkPermission(code string, next echo.HandlerFunc) echo.Handler
 		return next(c)
 
 	}
+	return app.authenticateAny(fn)
+}
+
+// requireSession rejects requests authenticated with an API key. It guards
+// account management endpoints, so that a leaked key can't be used to mint
+// new keys or to sign the owner out.
+func (app *app) requireSession(next echo.HandlerFunc) echo.HandlerFunc {
+	fn := func(c echo.Context) error {
+		session := c.Get("session").(*data.Token)
+
+		if session.Scope != data.ScopeAuthentication {
+			return c.JSON(http.StatusForbidden, map[string]string{
+				"message": "this endpoint cannot be used with an API key",
+			})
+		}
+
+		return next(c)
+	}
 	return app.authenticate(fn)
 }
 
@@ -0,0 +1,202 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/go-playground/validator/v10"
+	"github.com/labstack/echo/v4"
+	"github.com/mayank12gt/movie-webapp/internal/data"
+)
+
+var moderationQueueSortSafelist = []string{"oldest", "newest", "flags"}
+
+// screen runs submitted text through the banned-word filter. It returns the
+// status to save the content with and, when it is held, the reasons.
+func (app *app) screen(text ...string) (string, []string) {
+	if reasons := app.filter.Check(text...); len(reasons) > 0 {
+		return data.StatusHeld, reasons
+	}
+	return data.StatusPublished, nil
+}
+
+// flagContentHandler lets a signed in user report content of the given type,
+// identified by the :id path parameter, to the moderators.
+func (app *app) flagContentHandler(contentType string) func(c echo.Context) error {
+	return func(c echo.Context) error {
+		contentID, err := readIDParam(c, "id")
+		if err != nil {
+			return c.JSON(http.StatusBadRequest, map[string]string{
+				"message": err.Error(),
+			})
+		}
+
+		var input struct {
+			Reason string `json:"reason" validate:"required,max=500"`
+		}
+		if err := c.Bind(&input); err != nil {
+			return c.JSON(http.StatusBadRequest, map[string]string{
+				"message": "Bad Request, verify the json body",
+			})
+		}
+		input.Reason = strings.TrimSpace(input.Reason)
+
+		validate := validator.New()
+		if err := validate.Struct(input); err != nil {
+			return c.JSON(422, map[string]string{
+				"message": err.Error(),
+			})
+		}
+
+		user := c.Get("user").(*data.User)
+
+		if err := app.models.Moderation.Flag(contentType, contentID, user.ID, input.Reason); err != nil {
+			if errors.Is(err, data.ErrRecordNotFound) {
+				return c.JSON(404, map[string]string{
+					"message": "records not found",
+				})
+			}
+			return c.JSON(500, map[string]string{
+				"message": "Internal Server Error",
+			})
+		}
+
+		return c.JSON(http.StatusAccepted, map[string]string{
+			"message": "thanks, a moderator will look at it",
+		})
+	}
+}
+
+func (app *app) listModerationQueueHandler() func(c echo.Context) error {
+	return func(c echo.Context) error {
+		filters, err := readFilters(c, "oldest", moderationQueueSortSafelist)
+		if err != nil {
+			return c.JSON(http.StatusBadRequest, map[string]string{
+				"message": err.Error(),
+			})
+		}
+
+		validate := validator.New()
+		if err := validate.Struct(filters); err != nil {
+			return c.JSON(422, map[string]string{
+				"message": err.Error(),
+			})
+		}
+		if !filters.ValidSort() {
+			return c.JSON(422, map[string]string{
+				"message": "sort must be one of " + strings.Join(moderationQueueSortSafelist, " "),
+			})
+		}
+
+		items, meta, err := app.models.Moderation.Queue(filters)
+		if err != nil {
+			return c.JSON(500, map[string]string{
+				"message": "Internal Server Error",
+			})
+		}
+
+		return c.JSON(200, map[string]interface{}{
+			"metadata": meta,
+			"queue":    items,
+		})
+	}
+}
+
+// createModerationActionHandler hides, restores or deletes a piece of
+// content, taking it off the queue and logging the moderator's decision.
+func (app *app) createModerationActionHandler() func(c echo.Context) error {
+	return func(c echo.Context) error {
+		var action data.ModerationAction
+		if err := c.Bind(&action); err != nil {
+			return c.JSON(http.StatusBadRequest, map[string]string{
+				"message": "Bad Request, verify the json body",
+			})
+		}
+
+		user := c.Get("user").(*data.User)
+		action.ID = 0
+		action.ModeratorID = &user.ID
+		action.Note = strings.TrimSpace(action.Note)
+
+		validate := validator.New()
+		if err := validate.Struct(action); err != nil {
+			return c.JSON(422, map[string]string{
+				"message": err.Error(),
+			})
+		}
+		if !contains(data.ContentTypes(), action.ContentType) {
+			return c.JSON(422, map[string]string{
+				"message": "content_type must be one of " + strings.Join(data.ContentTypes(), " "),
+			})
+		}
+
+		if err := app.models.Moderation.Moderate(&action); err != nil {
+			if errors.Is(err, data.ErrRecordNotFound) {
+				return c.JSON(404, map[string]string{
+					"message": "records not found",
+				})
+			}
+			return c.JSON(500, map[string]string{
+				"message": "Internal Server Error",
+			})
+		}
+
+		app.logger.Printf("moderator %d: %s %s %d", user.ID, action.Action, action.ContentType, action.ContentID)
+
+		return c.JSON(201, action)
+	}
+}
+
+func (app *app) listModerationActionsHandler() func(c echo.Context) error {
+	return func(c echo.Context) error {
+		filters, err := readFilters(c, "newest", []string{"newest"})
+		if err != nil {
+			return c.JSON(http.StatusBadRequest, map[string]string{
+				"message": err.Error(),
+			})
+		}
+
+		validate := validator.New()
+		if err := validate.Struct(filters); err != nil {
+			return c.JSON(422, map[string]string{
+				"message": err.Error(),
+			})
+		}
+		if !filters.ValidSort() {
+			return c.JSON(422, map[string]string{
+				"message": "sort must be newest",
+			})
+		}
+
+		contentType := c.QueryParam("content_type")
+		if contentType != "" && !contains(data.ContentTypes(), contentType) {
+			return c.JSON(422, map[string]string{
+				"message": "content_type must be one of " + strings.Join(data.ContentTypes(), " "),
+			})
+		}
+
+		var contentID int64
+		if c.QueryParam("content_id") != "" {
+			contentID, err = strconv.ParseInt(c.QueryParam("content_id"), 10, 64)
+			if err != nil || contentID < 1 {
+				return c.JSON(http.StatusBadRequest, map[string]string{
+					"message": "content_id must be a positive integer",
+				})
+			}
+		}
+
+		actions, meta, err := app.models.Moderation.Actions(contentType, contentID, filters)
+		if err != nil {
+			return c.JSON(500, map[string]string{
+				"message": "Internal Server Error",
+			})
+		}
+
+		return c.JSON(200, map[string]interface{}{
+			"metadata": meta,
+			"actions":  actions,
+		})
+	}
+}
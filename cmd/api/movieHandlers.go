@@ -12,6 +12,8 @@ import (
 	"github.com/mayank12gt/movie-webapp/internal/data"
 )
 
+var movieSortSafelist = []string{"id", "title", "year", "runtime", "rating", "rating_count", "-id", "-title", "-year", "-runtime", "-rating", "-rating_count"}
+
 type Response struct {
 	// Metadata map[string]interface{} `json:"metadata"`
 	MetaData data.Metadata `json:"metadata"`
@@ -72,6 +74,25 @@ func (app *app) getMovieHandler() func(c echo.Context) error {
 				"message": "Internal Server Error",
 			})
 		}
+
+		if userID := currentUserID(c); userID != 0 {
+			inWatchlist, err := app.models.Watchlist.Contains(userID, movie.ID)
+			if err != nil {
+				return c.JSON(500, map[string]string{
+					"message": "Internal Server Error",
+				})
+			}
+			movie.InWatchlist = &inWatchlist
+		}
+
+		if err := app.loadImages(movie); err != nil {
+			return c.JSON(500, map[string]string{
+				"message": "Internal Server Error",
+			})
+		}
+
+		app.recordView(c, movie.ID)
+
 		return c.JSON(200, movie)
 
 	}
@@ -86,11 +107,26 @@ func (app *app) deleteMovieHandler() func(c echo.Context) error {
 			})
 		}
 
+		movieImages, err := app.models.Images.ForMovie(int64(id))
+		if err != nil {
+			return c.JSON(http.StatusInternalServerError, map[string]string{
+				"message": "Internal Server Error",
+			})
+		}
+
 		if err := app.models.Movies.Delete(int64(id)); err != nil {
 			return c.JSON(http.StatusInternalServerError, map[string]string{
 				"message": "Internal Server Error",
 			})
 		}
+
+		// The image rows went with the movie, the files have to be deleted.
+		app.background(func() {
+			for _, image := range movieImages {
+				app.deleteImageFiles(image)
+			}
+		})
+
 		return c.JSON(200, map[string]string{
 			"message": "Movie deleted",
 		})
@@ -182,8 +218,9 @@ func (app *app) listMovieHandler() func(c echo.Context) error {
 	return func(c echo.Context) error {
 
 		var input struct {
-			Title  string
-			Genres []string
+			Title    string
+			Genres   []string
+			PersonID int64 `validate:"min=0"`
 			data.Filters
 		}
 
@@ -195,44 +232,21 @@ func (app *app) listMovieHandler() func(c echo.Context) error {
 		}
 
 		var err error
-		if c.QueryParam("page") != "" {
-			input.Filters.Page, err = strconv.Atoi(c.QueryParam("page"))
+		if c.QueryParam("person") != "" {
+			input.PersonID, err = strconv.ParseInt(c.QueryParam("person"), 10, 64)
 			if err != nil {
 				return c.JSON(http.StatusBadRequest, map[string]string{
-					"message": "page must be integer",
+					"message": "person must be integer",
 				})
 			}
-		} else {
-			input.Filters.Page = 1
 		}
 
-		if c.QueryParam("page_size") != "" {
-			input.Filters.PageSize, err = strconv.Atoi(c.QueryParam("page_size"))
-			if err != nil {
-				return c.JSON(http.StatusBadRequest, map[string]string{
-					"message": "page must be integer",
-				})
-			}
-		} else {
-			input.Filters.PageSize = 20
-		}
-
-		input.Filters.Sort = c.QueryParam("sort")
-		if input.Filters.Sort == "" {
-			input.Filters.Sort = "id"
-		}
-		// if input.Filters.Page == 0 {
-		// 	input.Filters.Page = 1
-		// }
-		// if input.Genres == nil {
-		// 	input.Genres = []string{}
-		// }
-		if input.Title == "" {
-			input.Title = ""
+		input.Filters, err = readFilters(c, "id", movieSortSafelist)
+		if err != nil {
+			return c.JSON(http.StatusBadRequest, map[string]string{
+				"message": err.Error(),
+			})
 		}
-		// if input.Filters.PageSize == 0 {
-		// 	input.Filters.PageSize = 20
-		// }
 
 		validate := validator.New()
 
@@ -244,10 +258,15 @@ func (app *app) listMovieHandler() func(c echo.Context) error {
 				"message": err.Error(),
 			})
 		}
+		if !input.Filters.ValidSort() {
+			return c.JSON(422, map[string]string{
+				"message": "sort must be one of " + strings.Join(movieSortSafelist, " "),
+			})
+		}
 
 		app.logger.Print(input)
 
-		movies, meta, err := app.models.Movies.List(input.Title, input.Genres, input.Filters)
+		movies, meta, err := app.models.Movies.List(input.Title, input.Genres, input.PersonID, input.Filters)
 		if err != nil {
 			if errors.Is(err, sql.ErrNoRows) {
 				return c.JSON(404, map[string]string{
@@ -257,6 +276,12 @@ func (app *app) listMovieHandler() func(c echo.Context) error {
 			return c.JSON(http.StatusNotFound, err.Error())
 		}
 
+		if err := app.loadImages(movies...); err != nil {
+			return c.JSON(500, map[string]string{
+				"message": "Internal Server Error",
+			})
+		}
+
 		res := Response{
 			MetaData: meta,
 			Movies:   movies,
@@ -279,7 +304,8 @@ func (app *app) submitMovieRatingHandler() func(c echo.Context) error {
 		}
 
 		var input struct {
-			Rating float64 `json:"rating" validate:"min=1"`
+			Rating float64      `json:"rating"`
+			Review *reviewInput `json:"review"`
 		}
 		user := c.Get("user").(*data.User)
 
@@ -292,13 +318,43 @@ func (app *app) submitMovieRatingHandler() func(c echo.Context) error {
 			Movie_id: int64(movie_ID),
 			Rating:   input.Rating,
 		}
+		if !app.config.ratings.scale.Valid(rating.Rating) {
+			return c.JSON(422, map[string]string{
+				"message": "rating must be " + app.config.ratings.scale.String(),
+			})
+		}
+
+		// The optional review is validated before anything is written, so a
+		// bad review doesn't leave a rating behind.
+		var review *data.Review
+		if input.Review != nil {
+			review = input.Review.toReview(user.ID, rating.Movie_id)
+
+			validate := validator.New()
+			if err := validate.Struct(review); err != nil {
+				return c.JSON(422, map[string]string{
+					"message": err.Error(),
+				})
+			}
+		}
+
+		var reasons []string
+		if review != nil {
+			review.Status, reasons = app.screen(review.Title, review.Body)
+			rating.Review = review
+		}
 
-		if err := app.models.Ratings.AddRating(&rating); err != nil {
+		if err := app.models.Ratings.AddRating(&rating, reasons); err != nil {
 			return c.JSON(500, map[string]string{
 				"message": "Internal Server Error",
 			})
 		}
+		app.recordActivity(rating.Movie_id, data.TrendingRating)
+		if review != nil && review.Status == data.StatusPublished {
+			app.recordActivity(rating.Movie_id, data.TrendingReview)
+		}
 
+		rating.Scale = &app.config.ratings.scale
 		return c.JSON(200, rating)
 	}
 }
@@ -323,10 +379,46 @@ func (app *app) getMovieAverageRatingHandler() func(c echo.Context) error {
 				"message": "Internal Server Error"})
 		}
 
+		avearageRating.Scale = &app.config.ratings.scale
 		return c.JSON(200, avearageRating)
 	}
 }
 
+func (app *app) getMovieRatingStatsHandler() func(c echo.Context) error {
+	return func(c echo.Context) error {
+		id, err := readIDParam(c, "id")
+		if err != nil {
+			return c.JSON(http.StatusBadRequest, map[string]string{
+				"message": err.Error(),
+			})
+		}
+
+		months := 12
+		if c.QueryParam("months") != "" {
+			months, err = strconv.Atoi(c.QueryParam("months"))
+			if err != nil || months < 1 || months > 120 {
+				return c.JSON(http.StatusBadRequest, map[string]string{
+					"message": "months must be an integer between 1 and 120",
+				})
+			}
+		}
+
+		stats, err := app.models.Movies.GetRatingStats(id, months, app.config.ratings.scale)
+		if err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				return c.JSON(404, map[string]string{
+					"message": "records not found",
+				})
+			}
+			return c.JSON(500, map[string]string{
+				"message": "Internal Server Error",
+			})
+		}
+
+		return c.JSON(200, stats)
+	}
+}
+
 func (app *app) updateMovieRatingHandler() func(c echo.Context) error {
 	return func(c echo.Context) error {
 		movie_ID, err := strconv.Atoi(c.Param("id"))
@@ -337,7 +429,7 @@ func (app *app) updateMovieRatingHandler() func(c echo.Context) error {
 		}
 
 		var input struct {
-			Rating float64 `json:"rating" validate:"min=1"`
+			Rating float64 `json:"rating"`
 		}
 		user := c.Get("user").(*data.User)
 
@@ -350,13 +442,24 @@ func (app *app) updateMovieRatingHandler() func(c echo.Context) error {
 			Movie_id: int64(movie_ID),
 			Rating:   input.Rating,
 		}
+		if !app.config.ratings.scale.Valid(rating.Rating) {
+			return c.JSON(422, map[string]string{
+				"message": "rating must be " + app.config.ratings.scale.String(),
+			})
+		}
 		app.logger.Print(rating)
 		if err := app.models.Ratings.UpdateRating(&rating); err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				return c.JSON(404, map[string]string{
+					"message": "records not found",
+				})
+			}
 			return c.JSON(500, map[string]string{
 				"message": "Internal Server Error",
 			})
 		}
 
+		rating.Scale = &app.config.ratings.scale
 		return c.JSON(200, rating)
 	}
 }
@@ -377,7 +480,7 @@ func (app *app) deleteMovieRatingHandler() func(c echo.Context) error {
 			Movie_id: int64(movie_ID),
 		}
 		if err := app.models.Ratings.DeleteRating(&rating); err != nil {
-			if errors.Is(err, sql.ErrNoRows) {
+			if errors.Is(err, data.ErrRecordNotFound) {
 				return c.JSON(404, map[string]string{
 					"message": "records not found",
 				})
@@ -418,6 +521,7 @@ func (app *app) getMovieRatingHandler() func(c echo.Context) error {
 			return c.JSON(500, err.Error())
 		}
 
+		rating.Scale = &app.config.ratings.scale
 		return c.JSON(200, rating)
 	}
 }
@@ -0,0 +1,274 @@
+package main
+
+import (
+	"crypto/subtle"
+	"database/sql"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/lib/pq"
+	"github.com/mayank12gt/movie-webapp/internal/data"
+	"github.com/mayank12gt/movie-webapp/internal/oidc"
+)
+
+const oidcLoginTTL = 10 * time.Minute
+
+// oidcLoginHandler starts an authorization code flow with PKCE. The state is
+// stored server side with its nonce and code verifier, and also set as a
+// cookie so that the callback only completes in the browser that began it.
+func (app *app) oidcLoginHandler() func(c echo.Context) error {
+	return func(c echo.Context) error {
+
+		if app.oidc == nil {
+			return c.JSON(http.StatusNotFound, map[string]string{
+				"message": "OpenID Connect login is not configured",
+			})
+		}
+
+		var values [3]string
+		for i := range values {
+			value, err := oidc.RandomString()
+			if err != nil {
+				return c.JSON(500, map[string]string{
+					"message": "Internal Server Error",
+				})
+			}
+			values[i] = value
+		}
+		state, nonce, codeVerifier := values[0], values[1], values[2]
+
+		authURL, err := app.oidc.AuthCodeURL(c.Request().Context(), state, nonce, codeVerifier)
+		if err != nil {
+			app.logger.Print(err)
+			return c.JSON(http.StatusBadGateway, map[string]string{
+				"message": "identity provider is unavailable",
+			})
+		}
+
+		if err := app.models.Identities.SaveAuthRequest(state, nonce, codeVerifier, oidcLoginTTL); err != nil {
+			return c.JSON(500, map[string]string{
+				"message": "Internal Server Error",
+			})
+		}
+
+		c.SetCookie(&http.Cookie{
+			Name:     "oidc_state",
+			Value:    state,
+			Secure:   false,
+			Expires:  time.Now().Add(oidcLoginTTL),
+			HttpOnly: true,
+			SameSite: http.SameSiteLaxMode,
+			Path:     "/users/oidc",
+		})
+
+		return c.Redirect(http.StatusFound, authURL)
+	}
+}
+
+func (app *app) oidcCallbackHandler() func(c echo.Context) error {
+	return func(c echo.Context) error {
+
+		if app.oidc == nil {
+			return c.JSON(http.StatusNotFound, map[string]string{
+				"message": "OpenID Connect login is not configured",
+			})
+		}
+
+		if providerErr := c.QueryParam("error"); providerErr != "" {
+			return c.JSON(http.StatusUnauthorized, map[string]string{
+				"message": "identity provider returned " + providerErr,
+			})
+		}
+
+		state := c.QueryParam("state")
+		code := c.QueryParam("code")
+		if state == "" || code == "" {
+			return c.JSON(http.StatusBadRequest, map[string]string{
+				"message": "state and code are required",
+			})
+		}
+
+		cookie, err := c.Cookie("oidc_state")
+		if err != nil || subtle.ConstantTimeCompare([]byte(cookie.Value), []byte(state)) != 1 {
+			return c.JSON(http.StatusUnauthorized, map[string]string{
+				"message": "login state does not match, please start again",
+			})
+		}
+		c.SetCookie(&http.Cookie{
+			Name:     "oidc_state",
+			Value:    "",
+			Expires:  time.Now().Add(-time.Hour),
+			HttpOnly: true,
+			Path:     "/users/oidc",
+		})
+
+		nonce, codeVerifier, err := app.models.Identities.TakeAuthRequest(state)
+		if err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				return c.JSON(http.StatusUnauthorized, map[string]string{
+					"message": "login request expired, please start again",
+				})
+			}
+			return c.JSON(500, map[string]string{
+				"message": "Internal Server Error",
+			})
+		}
+
+		claims, err := app.oidc.Exchange(c.Request().Context(), code, codeVerifier, nonce)
+		if err != nil {
+			app.logger.Print(err)
+			if errors.Is(err, oidc.ErrInvalidToken) {
+				return c.JSON(http.StatusUnauthorized, map[string]string{
+					"message": "identity provider returned an invalid id token",
+				})
+			}
+			return c.JSON(http.StatusBadGateway, map[string]string{
+				"message": "could not complete login with the identity provider",
+			})
+		}
+
+		user, err := app.models.Identities.GetUser(claims.Issuer, claims.Subject)
+		if err != nil {
+			if !errors.Is(err, sql.ErrNoRows) {
+				return c.JSON(500, map[string]string{
+					"message": "Internal Server Error",
+				})
+			}
+			return app.linkOrCreateOIDCUser(c, claims)
+		}
+
+		return app.completeSignIn(c, user)
+	}
+}
+
+// linkOrCreateOIDCUser handles the first login of an external identity. It
+// is linked to an existing account with the same email only when the
+// provider vouches for the address; otherwise a new activated user with
+// movies:read is created.
+func (app *app) linkOrCreateOIDCUser(c echo.Context, claims *oidc.Claims) error {
+
+	if claims.Email == "" {
+		return c.JSON(422, map[string]string{
+			"message": "identity provider did not share an email address",
+		})
+	}
+
+	identity := &data.Identity{
+		Issuer:  claims.Issuer,
+		Subject: claims.Subject,
+		Email:   claims.Email,
+	}
+
+	user, err := app.models.Users.GetByEmail(claims.Email)
+	switch {
+	case err == nil:
+		if !claims.EmailVerified {
+			return c.JSON(http.StatusConflict, map[string]string{
+				"message": "an account with this email already exists, please sign in with your password",
+			})
+		}
+
+		// Whoever registered an account that was never activated didn't
+		// prove they own the email, and could be waiting for the owner to
+		// activate it. Their password and tokens go before the owner gets
+		// the account; the owner can set a password through the password
+		// reset flow.
+		if !user.Activated {
+			randomPassword, err := oidc.RandomString()
+			if err != nil {
+				return c.JSON(500, map[string]string{
+					"message": "Internal Server Error",
+				})
+			}
+			if err := user.Password.Set(randomPassword); err != nil {
+				return c.JSON(500, map[string]string{
+					"message": "Internal Server Error",
+				})
+			}
+
+			user.Activated = true
+			if err := app.models.Users.Update(user); err != nil {
+				return c.JSON(500, map[string]string{
+					"message": "Internal Server Error",
+				})
+			}
+
+			scopes := []string{data.ScopeActivation, data.ScopePasswordReset, data.ScopeAuthentication, data.ScopeRefresh, data.ScopeMFAPending, data.ScopeAPIKey}
+			for _, scope := range scopes {
+				if err := app.models.Tokens.DeleteAllForUser(user.ID, scope); err != nil {
+					return c.JSON(500, map[string]string{
+						"message": "Internal Server Error",
+					})
+				}
+			}
+		}
+
+		identity.UserID = user.ID
+		if err := app.models.Identities.Insert(identity); err != nil {
+			return c.JSON(500, map[string]string{
+				"message": "Internal Server Error",
+			})
+		}
+
+	case errors.Is(err, sql.ErrNoRows):
+		name := claims.Name
+		if name == "" {
+			name, _, _ = strings.Cut(claims.Email, "@")
+		}
+		// Names are limited to 100 characters, not bytes.
+		if runes := []rune(name); len(runes) > 100 {
+			name = string(runes[:100])
+		}
+
+		// The name is screened like one given at registration.
+		if status, _ := app.screen(name); status != data.StatusPublished {
+			return c.JSON(422, map[string]string{
+				"message": "name contains words that are not allowed",
+			})
+		}
+
+		user = &data.User{
+			Name:      name,
+			Email:     claims.Email,
+			Activated: true,
+		}
+
+		// The account has no usable password until the user sets one
+		// through the password reset flow.
+		randomPassword, err := oidc.RandomString()
+		if err != nil {
+			return c.JSON(500, map[string]string{
+				"message": "Internal Server Error",
+			})
+		}
+		if err := user.Password.Set(randomPassword); err != nil {
+			return c.JSON(500, map[string]string{
+				"message": "Internal Server Error",
+			})
+		}
+
+		if err := app.models.Identities.InsertWithUser(user, identity, "movies:read"); err != nil {
+			var pqErr *pq.Error
+			if errors.As(err, &pqErr) && pqErr.Code.Name() == "unique_violation" {
+				return c.JSON(http.StatusConflict, map[string]string{
+					"message": "this account was created concurrently, please sign in again",
+				})
+			}
+			return c.JSON(500, map[string]string{
+				"message": "Internal Server Error",
+			})
+		}
+
+		app.logger.Printf("created user %d for identity %s at %s", user.ID, claims.Subject, claims.Issuer)
+
+	default:
+		return c.JSON(500, map[string]string{
+			"message": "Internal Server Error",
+		})
+	}
+
+	return app.completeSignIn(c, user)
+}
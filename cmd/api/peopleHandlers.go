@@ -0,0 +1,358 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/go-playground/validator/v10"
+	"github.com/labstack/echo/v4"
+	"github.com/lib/pq"
+	"github.com/mayank12gt/movie-webapp/internal/data"
+)
+
+var personSortSafelist = []string{"id", "name", "birth_year", "-id", "-name", "-birth_year"}
+
+func (app *app) createPersonHandler() func(c echo.Context) error {
+	return func(c echo.Context) error {
+
+		var person data.Person
+
+		if err := c.Bind(&person); err != nil {
+			return c.JSON(http.StatusBadRequest, map[string]string{
+				"message": "Bad Request, verify JSON body",
+			})
+		}
+
+		validate := validator.New()
+		if err := validate.Struct(person); err != nil {
+			return c.JSON(422, map[string]string{
+				"message": err.Error(),
+			})
+		}
+
+		if err := app.models.People.Insert(&person); err != nil {
+			return c.JSON(http.StatusInternalServerError, map[string]string{
+				"message": "Internal Server Error",
+			})
+		}
+
+		return c.JSON(200, person)
+	}
+}
+
+func (app *app) getPersonHandler() func(c echo.Context) error {
+	return func(c echo.Context) error {
+		id, err := readIDParam(c, "id")
+		if err != nil {
+			return c.JSON(http.StatusBadRequest, map[string]string{
+				"message": err.Error(),
+			})
+		}
+
+		person, err := app.models.People.Get(id)
+		if err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				return c.JSON(404, map[string]string{
+					"message": "records not found",
+				})
+			}
+			return c.JSON(500, map[string]string{
+				"message": "Internal Server Error",
+			})
+		}
+
+		return c.JSON(200, person)
+	}
+}
+
+func (app *app) updatePersonHandler() func(c echo.Context) error {
+	return func(c echo.Context) error {
+
+		var input struct {
+			Name      *string `json:"name"`
+			BirthYear *int32  `json:"birth_year"`
+			Biography *string `json:"biography"`
+		}
+
+		id, err := readIDParam(c, "id")
+		if err != nil {
+			return c.JSON(http.StatusBadRequest, map[string]string{
+				"message": err.Error(),
+			})
+		}
+
+		if err := c.Bind(&input); err != nil {
+			return c.JSON(http.StatusBadRequest, map[string]string{
+				"message": "Bad Request, verify the JSON body",
+			})
+		}
+
+		person, err := app.models.People.Get(id)
+		if err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				return c.JSON(404, map[string]string{
+					"message": "no records found",
+				})
+			}
+			return c.JSON(500, map[string]string{
+				"message": "Internal Server Error",
+			})
+		}
+
+		if input.Name != nil {
+			person.Name = *input.Name
+		}
+		if input.BirthYear != nil {
+			person.BirthYear = *input.BirthYear
+		}
+		if input.Biography != nil {
+			person.Biography = *input.Biography
+		}
+
+		validate := validator.New()
+		if err := validate.Struct(person); err != nil {
+			return c.JSON(422, map[string]string{
+				"message": err.Error(),
+			})
+		}
+
+		if err := app.models.People.Update(person); err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				return c.JSON(http.StatusConflict, map[string]string{
+					"message": "person was modified concurrently, please retry",
+				})
+			}
+			return c.JSON(500, map[string]string{
+				"message": "Internal Server Error",
+			})
+		}
+
+		return c.JSON(200, person)
+	}
+}
+
+func (app *app) deletePersonHandler() func(c echo.Context) error {
+	return func(c echo.Context) error {
+		id, err := readIDParam(c, "id")
+		if err != nil {
+			return c.JSON(http.StatusBadRequest, map[string]string{
+				"message": err.Error(),
+			})
+		}
+
+		if err := app.models.People.Delete(id); err != nil {
+			if errors.Is(err, data.ErrRecordNotFound) {
+				return c.JSON(404, map[string]string{
+					"message": "records not found",
+				})
+			}
+			return c.JSON(http.StatusInternalServerError, map[string]string{
+				"message": "Internal Server Error",
+			})
+		}
+
+		return c.JSON(200, map[string]string{
+			"message": "Person deleted",
+		})
+	}
+}
+
+func (app *app) listPeopleHandler() func(c echo.Context) error {
+	return func(c echo.Context) error {
+
+		var input struct {
+			Name string
+			data.Filters
+		}
+
+		input.Name = c.QueryParam("name")
+
+		var err error
+		input.Filters, err = readFilters(c, "name", personSortSafelist)
+		if err != nil {
+			return c.JSON(http.StatusBadRequest, map[string]string{
+				"message": err.Error(),
+			})
+		}
+
+		validate := validator.New()
+		if err := validate.Struct(input); err != nil {
+			return c.JSON(422, map[string]string{
+				"message": err.Error(),
+			})
+		}
+		if !input.Filters.ValidSort() {
+			return c.JSON(422, map[string]string{
+				"message": "sort must be one of " + strings.Join(personSortSafelist, " "),
+			})
+		}
+
+		people, meta, err := app.models.People.List(input.Name, input.Filters)
+		if err != nil {
+			return c.JSON(500, map[string]string{
+				"message": "Internal Server Error",
+			})
+		}
+
+		return c.JSON(200, map[string]interface{}{
+			"metadata": meta,
+			"people":   people,
+		})
+	}
+}
+
+func (app *app) getPersonFilmographyHandler() func(c echo.Context) error {
+	return func(c echo.Context) error {
+		id, err := readIDParam(c, "id")
+		if err != nil {
+			return c.JSON(http.StatusBadRequest, map[string]string{
+				"message": err.Error(),
+			})
+		}
+
+		person, err := app.models.People.Get(id)
+		if err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				return c.JSON(404, map[string]string{
+					"message": "records not found",
+				})
+			}
+			return c.JSON(500, map[string]string{
+				"message": "Internal Server Error",
+			})
+		}
+
+		credits, err := app.models.Credits.GetAllForPerson(person.ID)
+		if err != nil {
+			return c.JSON(500, map[string]string{
+				"message": "Internal Server Error",
+			})
+		}
+
+		return c.JSON(200, map[string]interface{}{
+			"person":      person,
+			"filmography": credits,
+		})
+	}
+}
+
+func (app *app) listMovieCreditsHandler() func(c echo.Context) error {
+	return func(c echo.Context) error {
+		id, err := readIDParam(c, "id")
+		if err != nil {
+			return c.JSON(http.StatusBadRequest, map[string]string{
+				"message": err.Error(),
+			})
+		}
+
+		movie, err := app.models.Movies.Get(id)
+		if err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				return c.JSON(404, map[string]string{
+					"message": "records not found",
+				})
+			}
+			return c.JSON(500, map[string]string{
+				"message": "Internal Server Error",
+			})
+		}
+
+		credits, err := app.models.Credits.GetAllForMovie(movie.ID)
+		if err != nil {
+			return c.JSON(500, map[string]string{
+				"message": "Internal Server Error",
+			})
+		}
+
+		return c.JSON(200, map[string]interface{}{
+			"movie_id": movie.ID,
+			"credits":  credits,
+		})
+	}
+}
+
+func (app *app) createMovieCreditHandler() func(c echo.Context) error {
+	return func(c echo.Context) error {
+		movieID, err := readIDParam(c, "id")
+		if err != nil {
+			return c.JSON(http.StatusBadRequest, map[string]string{
+				"message": err.Error(),
+			})
+		}
+
+		var credit data.Credit
+		if err := c.Bind(&credit); err != nil {
+			return c.JSON(http.StatusBadRequest, map[string]string{
+				"message": "Bad Request, verify JSON body",
+			})
+		}
+		credit.MovieID = movieID
+
+		validate := validator.New()
+		if err := validate.Struct(credit); err != nil {
+			return c.JSON(422, map[string]string{
+				"message": err.Error(),
+			})
+		}
+		if credit.Role != data.RoleActor && (credit.Character != "" || credit.BillingOrder != 0) {
+			return c.JSON(422, map[string]string{
+				"message": "character and billing_order are only valid for actors",
+			})
+		}
+
+		if err := app.models.Credits.Insert(&credit); err != nil {
+			var pqErr *pq.Error
+			if errors.As(err, &pqErr) {
+				switch pqErr.Code.Name() {
+				case "foreign_key_violation":
+					return c.JSON(404, map[string]string{
+						"message": "movie or person not found",
+					})
+				case "unique_violation":
+					return c.JSON(http.StatusBadRequest, map[string]string{
+						"message": "credit already exists",
+					})
+				}
+			}
+			return c.JSON(http.StatusInternalServerError, map[string]string{
+				"message": "Internal Server Error",
+			})
+		}
+
+		return c.JSON(200, credit)
+	}
+}
+
+func (app *app) deleteMovieCreditHandler() func(c echo.Context) error {
+	return func(c echo.Context) error {
+		movieID, err := readIDParam(c, "id")
+		if err != nil {
+			return c.JSON(http.StatusBadRequest, map[string]string{
+				"message": err.Error(),
+			})
+		}
+		creditID, err := readIDParam(c, "credit_id")
+		if err != nil {
+			return c.JSON(http.StatusBadRequest, map[string]string{
+				"message": err.Error(),
+			})
+		}
+
+		if err := app.models.Credits.Delete(movieID, creditID); err != nil {
+			if errors.Is(err, data.ErrRecordNotFound) {
+				return c.JSON(404, map[string]string{
+					"message": "records not found",
+				})
+			}
+			return c.JSON(500, map[string]string{
+				"message": "Internal Server Error",
+			})
+		}
+
+		return c.JSON(200, map[string]string{
+			"message": "Credit deleted",
+		})
+	}
+}
@@ -0,0 +1,184 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/go-playground/validator/v10"
+	"github.com/labstack/echo/v4"
+	"github.com/mayank12gt/movie-webapp/internal/data"
+)
+
+func (app *app) listPermissionsHandler() func(c echo.Context) error {
+	return func(c echo.Context) error {
+
+		permissions, err := app.models.Permissions.ListAll()
+		if err != nil {
+			return c.JSON(500, map[string]string{
+				"message": "Internal Server Error",
+			})
+		}
+
+		return c.JSON(200, map[string]interface{}{
+			"permissions": permissions,
+		})
+	}
+}
+
+func (app *app) getUserPermissionsHandler() func(c echo.Context) error {
+	return func(c echo.Context) error {
+		userID, err := readIDParam(c, "id")
+		if err != nil {
+			return c.JSON(http.StatusBadRequest, map[string]string{
+				"message": err.Error(),
+			})
+		}
+
+		user, err := app.models.Users.Get(userID)
+		if err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				return c.JSON(404, map[string]string{
+					"message": "records not found",
+				})
+			}
+			return c.JSON(500, map[string]string{
+				"message": "Internal Server Error",
+			})
+		}
+
+		return app.writeUserPermissions(c, user.ID)
+	}
+}
+
+func (app *app) grantUserPermissionsHandler() func(c echo.Context) error {
+	return app.changeUserPermissions(func(userID int64, codes []string) error {
+		return app.models.Permissions.AddForUser(userID, codes...)
+	})
+}
+
+func (app *app) revokeUserPermissionsHandler() func(c echo.Context) error {
+	return app.changeUserPermissions(func(userID int64, codes []string) error {
+		return app.models.Permissions.RemoveForUser(userID, codes...)
+	})
+}
+
+// changeUserPermissions holds the input handling shared by the grant and
+// revoke endpoints, which differ only in the model call they make.
+func (app *app) changeUserPermissions(apply func(userID int64, codes []string) error) func(c echo.Context) error {
+	return func(c echo.Context) error {
+
+		var input struct {
+			Codes []string `json:"codes" validate:"required,min=1,unique,dive,required"`
+		}
+
+		userID, err := readIDParam(c, "id")
+		if err != nil {
+			return c.JSON(http.StatusBadRequest, map[string]string{
+				"message": err.Error(),
+			})
+		}
+
+		if err := c.Bind(&input); err != nil {
+			return c.JSON(http.StatusBadRequest, map[string]string{
+				"message": "Bad Request, verify json body",
+			})
+		}
+
+		validate := validator.New()
+		if err := validate.Struct(input); err != nil {
+			return c.JSON(422, map[string]string{
+				"message": err.Error(),
+			})
+		}
+
+		known, err := app.models.Permissions.ListAll()
+		if err != nil {
+			return c.JSON(500, map[string]string{
+				"message": "Internal Server Error",
+			})
+		}
+		for _, code := range input.Codes {
+			if !known.Include(code) {
+				return c.JSON(422, map[string]string{
+					"message": "unknown permission code " + code + ", must be one of " + strings.Join(known, " "),
+				})
+			}
+		}
+
+		user, err := app.models.Users.Get(userID)
+		if err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				return c.JSON(404, map[string]string{
+					"message": "records not found",
+				})
+			}
+			return c.JSON(500, map[string]string{
+				"message": "Internal Server Error",
+			})
+		}
+
+		if err := apply(user.ID, input.Codes); err != nil {
+			return c.JSON(500, map[string]string{
+				"message": "Internal Server Error",
+			})
+		}
+
+		app.logger.Printf("permissions %v changed for user %d by user %d", input.Codes, user.ID, c.Get("user").(*data.User).ID)
+
+		return app.writeUserPermissions(c, user.ID)
+	}
+}
+
+func (app *app) writeUserPermissions(c echo.Context, userID int64) error {
+	permissions, err := app.models.Permissions.GetAllForUser(userID)
+	if err != nil {
+		return c.JSON(500, map[string]string{
+			"message": "Internal Server Error",
+		})
+	}
+	if permissions == nil {
+		permissions = data.Permissions{}
+	}
+
+	return c.JSON(200, map[string]interface{}{
+		"user_id":     userID,
+		"permissions": permissions,
+	})
+}
+
+func (app *app) unlockUserHandler() func(c echo.Context) error {
+	return func(c echo.Context) error {
+		userID, err := readIDParam(c, "id")
+		if err != nil {
+			return c.JSON(http.StatusBadRequest, map[string]string{
+				"message": err.Error(),
+			})
+		}
+
+		user, err := app.models.Users.Get(userID)
+		if err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				return c.JSON(404, map[string]string{
+					"message": "records not found",
+				})
+			}
+			return c.JSON(500, map[string]string{
+				"message": "Internal Server Error",
+			})
+		}
+
+		if err := app.models.Throttles.Reset(emailThrottleKey(user.Email)); err != nil {
+			return c.JSON(500, map[string]string{
+				"message": "Internal Server Error",
+			})
+		}
+
+		app.logger.Printf("account %d unlocked by user %d", user.ID, c.Get("user").(*data.User).ID)
+
+		return c.JSON(200, map[string]string{
+			"message": "account unlocked",
+		})
+	}
+}
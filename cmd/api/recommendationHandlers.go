@@ -0,0 +1,165 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/go-playground/validator/v10"
+	"github.com/labstack/echo/v4"
+	"github.com/mayank12gt/movie-webapp/internal/data"
+)
+
+// computeSimilarities rebuilds the movie similarities every interval until
+// stop is closed, starting straight away. A rebuild in progress when stop is
+// closed is finished before shutdown completes.
+func (app *app) computeSimilarities(interval time.Duration, stop <-chan struct{}) {
+	app.background(func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			started := time.Now()
+			stored, err := app.models.Recommendations.ComputeSimilarities()
+			if err != nil {
+				app.logger.Printf("computing movie similarities: %v", err)
+			} else {
+				app.logger.Printf("computed %d movie similarities in %s", stored, time.Since(started).Round(time.Millisecond))
+			}
+
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+			}
+		}
+	})
+}
+
+func (app *app) listRecommendationsHandler() func(c echo.Context) error {
+	return func(c echo.Context) error {
+		filters, err := readFilters(c, "predicted", []string{"predicted"})
+		if err != nil {
+			return c.JSON(http.StatusBadRequest, map[string]string{
+				"message": err.Error(),
+			})
+		}
+
+		validate := validator.New()
+		if err := validate.Struct(filters); err != nil {
+			return c.JSON(422, map[string]string{
+				"message": err.Error(),
+			})
+		}
+		if !filters.ValidSort() {
+			return c.JSON(422, map[string]string{
+				"message": "sort must be predicted",
+			})
+		}
+
+		user := c.Get("user").(*data.User)
+
+		recommendations, meta, err := app.models.Recommendations.ForUser(user.ID, filters)
+		if err != nil {
+			return c.JSON(500, map[string]string{
+				"message": "Internal Server Error",
+			})
+		}
+
+		movies := make([]*data.Movie, len(recommendations))
+		for i, entry := range recommendations {
+			movies[i] = entry.Movie
+		}
+		if err := app.loadImages(movies...); err != nil {
+			return c.JSON(500, map[string]string{
+				"message": "Internal Server Error",
+			})
+		}
+
+		return c.JSON(200, map[string]interface{}{
+			"metadata":        meta,
+			"recommendations": recommendations,
+		})
+	}
+}
+
+func (app *app) listSimilarMoviesHandler() func(c echo.Context) error {
+	return func(c echo.Context) error {
+		id, err := readIDParam(c, "id")
+		if err != nil {
+			return c.JSON(http.StatusBadRequest, map[string]string{
+				"message": err.Error(),
+			})
+		}
+
+		filters, err := readFilters(c, "similarity", []string{"similarity"})
+		if err != nil {
+			return c.JSON(http.StatusBadRequest, map[string]string{
+				"message": err.Error(),
+			})
+		}
+
+		validate := validator.New()
+		if err := validate.Struct(filters); err != nil {
+			return c.JSON(422, map[string]string{
+				"message": err.Error(),
+			})
+		}
+		if !filters.ValidSort() {
+			return c.JSON(422, map[string]string{
+				"message": "sort must be similarity",
+			})
+		}
+
+		mode := c.QueryParam("mode")
+		if mode == "" {
+			mode = "ratings"
+		}
+		if mode != "ratings" && mode != "content" {
+			return c.JSON(422, map[string]string{
+				"message": "mode must be one of ratings content",
+			})
+		}
+
+		if _, err := app.models.Movies.Get(id); err != nil {
+			if errors.Is(err, sql.ErrNoRows) || errors.Is(err, data.ErrRecordNotFound) {
+				return c.JSON(404, map[string]string{
+					"message": "records not found",
+				})
+			}
+			return c.JSON(500, map[string]string{
+				"message": "Internal Server Error",
+			})
+		}
+
+		var similar []*data.SimilarMovie
+		var meta data.Metadata
+		if mode == "content" {
+			similar, meta, err = app.models.Recommendations.SimilarByContent(id, filters)
+		} else {
+			similar, meta, err = app.models.Recommendations.Similar(id, filters)
+		}
+		if err != nil {
+			return c.JSON(500, map[string]string{
+				"message": "Internal Server Error",
+			})
+		}
+
+		movies := make([]*data.Movie, len(similar))
+		for i, entry := range similar {
+			movies[i] = entry.Movie
+		}
+		if err := app.loadImages(movies...); err != nil {
+			return c.JSON(500, map[string]string{
+				"message": "Internal Server Error",
+			})
+		}
+
+		return c.JSON(200, map[string]interface{}{
+			"metadata": meta,
+			"mode":     mode,
+			"movies":   similar,
+		})
+	}
+}
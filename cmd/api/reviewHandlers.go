@@ -0,0 +1,230 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/go-playground/validator/v10"
+	"github.com/labstack/echo/v4"
+	"github.com/lib/pq"
+	"github.com/mayank12gt/movie-webapp/internal/data"
+)
+
+var reviewSortSafelist = []string{"newest", "oldest", "highest", "lowest", "helpful"}
+
+type reviewInput struct {
+	Title   string `json:"title"`
+	Body    string `json:"body"`
+	Spoiler bool   `json:"spoiler"`
+}
+
+func (input reviewInput) toReview(userID, movieID int64) *data.Review {
+	return &data.Review{
+		UserID:  userID,
+		MovieID: movieID,
+		Title:   strings.TrimSpace(input.Title),
+		Body:    strings.TrimSpace(input.Body),
+		Spoiler: input.Spoiler,
+	}
+}
+
+// saveReview screens a review with the banned-word filter and upserts it. A
+// held review is saved unpublished and put in the moderation queue. Only new
+// published reviews count towards the trending chart.
+func (app *app) saveReview(review *data.Review) error {
+	var reasons []string
+	review.Status, reasons = app.screen(review.Title, review.Body)
+
+	if err := app.models.Reviews.Upsert(review, reasons); err != nil {
+		return err
+	}
+	if review.Version == 1 && review.Status == data.StatusPublished {
+		app.recordActivity(review.MovieID, data.TrendingReview)
+	}
+
+	return nil
+}
+
+func (app *app) listMovieReviewsHandler() func(c echo.Context) error {
+	return func(c echo.Context) error {
+		movieID, err := readIDParam(c, "id")
+		if err != nil {
+			return c.JSON(http.StatusBadRequest, map[string]string{
+				"message": err.Error(),
+			})
+		}
+
+		filters, err := readFilters(c, "newest", reviewSortSafelist)
+		if err != nil {
+			return c.JSON(http.StatusBadRequest, map[string]string{
+				"message": err.Error(),
+			})
+		}
+
+		validate := validator.New()
+		if err := validate.Struct(filters); err != nil {
+			return c.JSON(422, map[string]string{
+				"message": err.Error(),
+			})
+		}
+		if !filters.ValidSort() {
+			return c.JSON(422, map[string]string{
+				"message": "sort must be one of " + strings.Join(reviewSortSafelist, " "),
+			})
+		}
+
+		reviews, meta, err := app.models.Reviews.ListForMovie(movieID, currentUserID(c), filters)
+		if err != nil {
+			return c.JSON(500, map[string]string{
+				"message": "Internal Server Error",
+			})
+		}
+
+		return c.JSON(200, map[string]interface{}{
+			"metadata": meta,
+			"reviews":  reviews,
+		})
+	}
+}
+
+// upsertMovieReviewHandler writes the caller's review of a movie they have
+// already rated, creating it or replacing an earlier version.
+func (app *app) upsertMovieReviewHandler() func(c echo.Context) error {
+	return func(c echo.Context) error {
+		movieID, err := readIDParam(c, "id")
+		if err != nil {
+			return c.JSON(http.StatusBadRequest, map[string]string{
+				"message": err.Error(),
+			})
+		}
+
+		var input reviewInput
+		if err := c.Bind(&input); err != nil {
+			return c.JSON(http.StatusBadRequest, map[string]string{
+				"message": "Bad Request, verify the json body",
+			})
+		}
+
+		user := c.Get("user").(*data.User)
+		review := input.toReview(user.ID, movieID)
+
+		validate := validator.New()
+		if err := validate.Struct(review); err != nil {
+			return c.JSON(422, map[string]string{
+				"message": err.Error(),
+			})
+		}
+
+		if err := app.saveReview(review); err != nil {
+			var pqErr *pq.Error
+			if errors.As(err, &pqErr) && pqErr.Code.Name() == "foreign_key_violation" {
+				return c.JSON(404, map[string]string{
+					"message": "rate the movie before reviewing it",
+				})
+			}
+			return c.JSON(500, map[string]string{
+				"message": "Internal Server Error",
+			})
+		}
+
+		return c.JSON(200, review)
+	}
+}
+
+func (app *app) deleteMovieReviewHandler() func(c echo.Context) error {
+	return func(c echo.Context) error {
+		movieID, err := readIDParam(c, "id")
+		if err != nil {
+			return c.JSON(http.StatusBadRequest, map[string]string{
+				"message": err.Error(),
+			})
+		}
+
+		user := c.Get("user").(*data.User)
+
+		if err := app.models.Reviews.Delete(user.ID, movieID); err != nil {
+			if errors.Is(err, data.ErrRecordNotFound) {
+				return c.JSON(404, map[string]string{
+					"message": "records not found",
+				})
+			}
+			return c.JSON(500, map[string]string{
+				"message": "Internal Server Error",
+			})
+		}
+
+		return c.JSON(200, map[string]string{
+			"message": "Review Deleted",
+		})
+	}
+}
+
+func (app *app) voteReviewHandler() func(c echo.Context) error {
+	return func(c echo.Context) error {
+		reviewID, err := readIDParam(c, "id")
+		if err != nil {
+			return c.JSON(http.StatusBadRequest, map[string]string{
+				"message": err.Error(),
+			})
+		}
+
+		var input struct {
+			Helpful *bool `json:"helpful" validate:"required"`
+		}
+		if err := c.Bind(&input); err != nil {
+			return c.JSON(http.StatusBadRequest, map[string]string{
+				"message": "Bad Request, verify the json body",
+			})
+		}
+
+		validate := validator.New()
+		if err := validate.Struct(input); err != nil {
+			return c.JSON(422, map[string]string{
+				"message": err.Error(),
+			})
+		}
+
+		user := c.Get("user").(*data.User)
+
+		review, err := app.models.Reviews.Vote(reviewID, user.ID, *input.Helpful)
+		return writeVoteResult(c, review, err)
+	}
+}
+
+func (app *app) deleteReviewVoteHandler() func(c echo.Context) error {
+	return func(c echo.Context) error {
+		reviewID, err := readIDParam(c, "id")
+		if err != nil {
+			return c.JSON(http.StatusBadRequest, map[string]string{
+				"message": err.Error(),
+			})
+		}
+
+		user := c.Get("user").(*data.User)
+
+		review, err := app.models.Reviews.RemoveVote(reviewID, user.ID)
+		return writeVoteResult(c, review, err)
+	}
+}
+
+func writeVoteResult(c echo.Context, review *data.Review, err error) error {
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrOwnReview):
+			return c.JSON(http.StatusForbidden, map[string]string{
+				"message": "you cannot vote on your own review",
+			})
+		case errors.Is(err, data.ErrRecordNotFound):
+			return c.JSON(404, map[string]string{
+				"message": "records not found",
+			})
+		default:
+			return c.JSON(500, map[string]string{
+				"message": "Internal Server Error",
+			})
+		}
+	}
+
+	return c.JSON(200, review)
+}
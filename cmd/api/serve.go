@@ -11,14 +11,23 @@ import (
 
 	"github.com/labstack/echo/v4"
 	"github.com/labstack/echo/v4/middleware"
+	"github.com/mayank12gt/movie-webapp/internal/data"
+	"github.com/mayank12gt/movie-webapp/internal/images"
 )
 
 func (app *app) serve() error {
 
 	server := echo.New()
+	server.IPExtractor = app.ipExtractor()
 	server.Use(middleware.CORS())
 	app.registerHandlers(server)
 
+	stopJobs := make(chan struct{})
+	if app.config.recommendations.interval > 0 {
+		app.computeSimilarities(app.config.recommendations.interval, stopJobs)
+	}
+	app.flushTrending(app.config.trending.flushInterval, stopJobs)
+
 	shutdownErr := make(chan error)
 
 	go func() {
@@ -38,6 +47,8 @@ func (app *app) serve() error {
 		}
 		app.logger.Print("Completing Background Tasks")
 
+		close(stopJobs)
+
 		app.wg.Wait()
 		shutdownErr <- nil
 
@@ -61,23 +72,120 @@ func (app *app) serve() error {
 	return nil
 }
 
+// ipExtractor decides which address c.RealIP returns. Sign in throttling and
+// session metadata rely on it, so client supplied headers are only believed
+// when they were set by a trusted proxy.
+func (app *app) ipExtractor() echo.IPExtractor {
+	if len(app.config.trustedProxies) == 0 {
+		return echo.ExtractIPDirect()
+	}
+
+	options := []echo.TrustOption{echo.TrustLoopback(false), echo.TrustLinkLocal(false), echo.TrustPrivateNet(false)}
+	for _, network := range app.config.trustedProxies {
+		options = append(options, echo.TrustIPRange(network))
+	}
+	return echo.ExtractIPFromXFFHeader(options...)
+}
+
 func (app *app) registerHandlers(server *echo.Echo) {
 	server.POST("/movies", app.checkPermission("movies:write", app.createMovieHandler()))
 	server.GET("/movies", app.listMovieHandler())
-	server.GET("/movies/:id", app.getMovieHandler())
+	server.GET("/movies/:id", app.getMovieHandler(), app.authenticateOptional)
 	server.DELETE("/movies/:id", app.checkPermission("movies:write", app.deleteMovieHandler()))
 	server.PUT("/movies/:id", app.checkPermission("movies:write", app.updateMovieHandler()))
+	server.POST("/movies/:id/images", app.checkPermission("movies:write", app.uploadMovieImageHandler()))
+	if store, ok := app.images.(*images.LocalStore); ok {
+		server.GET("/images/*", app.serveImageHandler(store))
+	}
 
 	server.POST("/movies/:id/ratings", app.submitMovieRatingHandler(), app.authenticate)
 	server.GET("/movies/:id/ratings", app.getMovieAverageRatingHandler())
+	server.GET("/movies/:id/ratings/stats", app.getMovieRatingStatsHandler())
 	server.GET("/movies/:id/rating", app.getMovieRatingHandler(), app.authenticate)
 	server.PUT("/movies/:id/ratings", app.updateMovieRatingHandler(), app.authenticate)
 	server.DELETE("/movies/:id/ratings", app.deleteMovieRatingHandler(), app.authenticate)
 
+	server.GET("/movies/:id/similar", app.listSimilarMoviesHandler())
+
+	server.GET("/movies/:id/reviews", app.listMovieReviewsHandler(), app.authenticateOptional)
+	server.PUT("/movies/:id/reviews", app.upsertMovieReviewHandler(), app.authenticate)
+	server.DELETE("/movies/:id/reviews", app.deleteMovieReviewHandler(), app.authenticate)
+	server.PUT("/reviews/:id/vote", app.voteReviewHandler(), app.authenticate)
+	server.DELETE("/reviews/:id/vote", app.deleteReviewVoteHandler(), app.authenticate)
+	server.POST("/reviews/:id/flags", app.flagContentHandler(data.ContentReview), app.authenticate)
+
+	server.GET("/lists", app.browseListsHandler())
+	server.POST("/lists", app.createListHandler(), app.authenticate)
+	server.GET("/lists/:id", app.getListHandler(), app.authenticateOptional)
+	server.PATCH("/lists/:id", app.updateListHandler(), app.authenticate)
+	server.DELETE("/lists/:id", app.deleteListHandler(), app.authenticate)
+	server.POST("/lists/:id/entries", app.addListEntryHandler(), app.authenticate)
+	server.PUT("/lists/:id/entries/:movie_id", app.updateListEntryHandler(), app.authenticate)
+	server.DELETE("/lists/:id/entries/:movie_id", app.deleteListEntryHandler(), app.authenticate)
+	server.PUT("/lists/:id/order", app.reorderListHandler(), app.authenticate)
+	server.POST("/lists/:id/flags", app.flagContentHandler(data.ContentList), app.authenticate)
+
+	server.GET("/moderation/queue", app.checkPermission("content:moderate", app.listModerationQueueHandler()))
+	server.GET("/moderation/actions", app.checkPermission("content:moderate", app.listModerationActionsHandler()))
+	server.POST("/moderation/actions", app.checkPermission("content:moderate", app.createModerationActionHandler()))
+
+	server.GET("/charts/top-rated", app.topRatedHandler())
+	server.GET("/charts/trending", app.trendingHandler())
+
+	server.GET("/movies/:id/credits", app.listMovieCreditsHandler())
+	server.POST("/movies/:id/credits", app.checkPermission("movies:write", app.createMovieCreditHandler()))
+	server.DELETE("/movies/:id/credits/:credit_id", app.checkPermission("movies:write", app.deleteMovieCreditHandler()))
+
+	server.POST("/people", app.checkPermission("movies:write", app.createPersonHandler()))
+	server.GET("/people", app.listPeopleHandler())
+	server.GET("/people/:id", app.getPersonHandler())
+	server.PUT("/people/:id", app.checkPermission("movies:write", app.updatePersonHandler()))
+	server.DELETE("/people/:id", app.checkPermission("movies:write", app.deletePersonHandler()))
+	server.GET("/people/:id/filmography", app.getPersonFilmographyHandler())
+
 	server.POST("/users", app.registerUserHandler())
 	server.POST("/users/activate", app.activateUserHandler())
 	server.POST("/users/authenticate", app.createAuthenticationTokenHandler())
+	server.POST("/users/authenticate/mfa", app.verifyMFAHandler())
+	server.GET("/users/oidc/login", app.oidcLoginHandler())
+	server.GET("/users/oidc/callback", app.oidcCallbackHandler())
+	server.POST("/users/token/refresh", app.refreshTokenHandler())
 	server.POST("/users/signOut", app.signOutHandler(), app.authenticate)
+	server.GET("/users/me/sessions", app.listSessionsHandler(), app.requireSession)
+	server.DELETE("/users/me/sessions", app.signOutEverywhereHandler(), app.requireSession)
+	server.DELETE("/users/me/sessions/:id", app.revokeSessionHandler(), app.requireSession)
+	server.POST("/users/me/api-keys", app.createAPIKeyHandler(), app.requireSession)
+	server.GET("/users/me/api-keys", app.listAPIKeysHandler(), app.requireSession)
+	server.DELETE("/users/me/api-keys/:id", app.deleteAPIKeyHandler(), app.requireSession)
+	server.POST("/users/me/mfa", app.beginMFAHandler(), app.requireSession)
+	server.POST("/users/me/mfa/confirm", app.confirmMFAHandler(), app.requireSession)
+	server.DELETE("/users/me/mfa", app.disableMFAHandler(), app.requireSession)
+	server.GET("/users/me/watchlist", app.listWatchlistHandler(), app.authenticate)
+	server.POST("/users/me/watchlist/:movie_id", app.addToWatchlistHandler(), app.authenticate)
+	server.DELETE("/users/me/watchlist/:movie_id", app.removeFromWatchlistHandler(), app.authenticate)
+	server.GET("/users/me/recommendations", app.listRecommendationsHandler(), app.authenticate)
+	server.GET("/users/me/feed", app.feedHandler(), app.authenticate)
+	server.PUT("/users/me/privacy", app.setPrivacyHandler(), app.requireSession)
+	server.GET("/users/me/follow-requests", app.listFollowRequestsHandler(), app.authenticate)
+	server.POST("/users/me/follow-requests/:id", app.approveFollowRequestHandler(), app.authenticate)
+	server.DELETE("/users/me/follow-requests/:id", app.rejectFollowRequestHandler(), app.authenticate)
+	server.POST("/users/:id/follow", app.followUserHandler(), app.authenticate)
+	server.DELETE("/users/:id/follow", app.unfollowUserHandler(), app.authenticate)
+	server.GET("/users/:id/followers", app.listFollowersHandler(), app.authenticateOptional)
+	server.GET("/users/:id/following", app.listFollowingHandler(), app.authenticateOptional)
+	server.GET("/users/me/lists", app.listMyListsHandler(), app.authenticate)
+	server.GET("/users/me/diary", app.listDiaryHandler(), app.authenticate)
+	server.POST("/users/me/diary", app.createDiaryEntryHandler(), app.authenticate)
+	server.GET("/users/me/diary/stats", app.diaryStatsHandler(), app.authenticate)
+	server.DELETE("/users/me/diary/:id", app.deleteDiaryEntryHandler(), app.authenticate)
+	server.POST("/users/password-reset", app.createPasswordResetTokenHandler())
+	server.PUT("/users/password", app.resetPasswordHandler())
+
+	server.GET("/permissions", app.checkPermission("permissions:admin", app.listPermissionsHandler()))
+	server.GET("/users/:id/permissions", app.checkPermission("permissions:admin", app.getUserPermissionsHandler()))
+	server.POST("/users/:id/permissions", app.checkPermission("permissions:admin", app.grantUserPermissionsHandler()))
+	server.DELETE("/users/:id/permissions", app.checkPermission("permissions:admin", app.revokeUserPermissionsHandler()))
+	server.POST("/users/:id/unlock", app.checkPermission("permissions:admin", app.unlockUserHandler()))
 
 	server.GET("/", func(c echo.Context) error {
 		//time.Sleep(4 * time.Second)
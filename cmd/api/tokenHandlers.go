@@ -4,6 +4,8 @@ import (
 	"database/sql"
 	"errors"
 	"net/http"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/go-playground/validator/v10"
@@ -35,80 +37,470 @@ func (app *app) createAuthenticationTokenHandler() func(c echo.Context) error {
 			})
 		}
 
+		emailKey := emailThrottleKey(input.Email)
+		ipKey := "ip:" + c.RealIP()
+
+		account, ip, lockedUntil, err := app.claimSignIn(emailKey, ipKey)
+		if err != nil {
+			return c.JSON(500, map[string]string{
+				"message": "Internal Server Error",
+			})
+		}
+		if account == nil {
+			return tooManyAttempts(c, lockedUntil, "too many failed sign in attempts, try again later")
+		}
+
+		// An unknown email and a wrong password get the same response, after
+		// the same amount of work, so that accounts can't be enumerated.
 		user, err := app.models.Users.GetByEmail(input.Email)
 		if err != nil {
 			if errors.Is(err, sql.ErrNoRows) {
-				return c.JSON(404, map[string]string{
-					"message": "records not found",
-				})
+				data.CompareDummyPassword(input.Password)
+				return app.failedSignIn(c, nil, account)
 			}
 			return c.JSON(500, map[string]string{
 				"message": "Internal Server Error",
 			})
 		}
+
+		match, err := user.Password.Compare(input.Password)
+		if err != nil {
+			return c.JSON(500, map[string]string{
+				"message": "Internal Server Error",
+			})
+		}
+		if !match {
+			return app.failedSignIn(c, user, account)
+		}
+
+		// The account's failures are only reset once the sign in is
+		// complete, so that the password alone can't reset them and buy
+		// more guesses at a 2FA code.
+		for _, claim := range []*data.ThrottleClaim{account, ip} {
+			if err := app.models.Throttles.Release(claim); err != nil {
+				return c.JSON(500, map[string]string{
+					"message": "Internal Server Error",
+				})
+			}
+		}
+
 		if !user.Activated {
 			return c.JSON(422, map[string]string{
 				"message": "User not activated",
 			})
 		}
 
-		match, err := user.Password.Compare(input.Password)
-		if err != nil {
+		return app.completeSignIn(c, user)
+	}
+}
+
+// completeSignIn is called once a user has proven who they are, by password
+// or through an identity provider. Users with 2FA get an "mfa pending" token
+// to exchange at /users/authenticate/mfa; everyone else gets a session.
+func (app *app) completeSignIn(c echo.Context, user *data.User) error {
+
+	mfaEnabled, err := app.models.MFA.IsEnabled(user.ID)
+	if err != nil {
+		return c.JSON(500, map[string]string{
+			"message": "Internal Server Error",
+		})
+	}
+	if mfaEnabled {
+		if err := app.models.MFA.ResetFailures(user.ID); err != nil {
 			return c.JSON(500, map[string]string{
 				"message": "Internal Server Error",
 			})
 		}
-		if !match {
-			return c.JSON(422, map[string]string{
-				"message": "password is incorrect",
+		token, err := app.models.Tokens.New(user.ID, mfaPendingTTL, data.ScopeMFAPending)
+		if err != nil {
+			return c.JSON(http.StatusInternalServerError, map[string]string{
+				"message": "Internal Server Error",
 			})
 		}
+		return c.JSON(200, map[string]interface{}{
+			"mfa_required": true,
+			"mfa_token":    token,
+		})
+	}
+
+	if err := app.models.Throttles.Reset(emailThrottleKey(user.Email)); err != nil {
+		return c.JSON(500, map[string]string{
+			"message": "Internal Server Error",
+		})
+	}
+
+	return app.startSession(c, user)
+}
 
-		token, err := app.models.Tokens.New(user.ID, 24*time.Hour, data.ScopeAuthentication)
+const (
+	accountLockoutThreshold = 5
+	ipLockoutThreshold      = 20
+	lockoutBase             = time.Minute
+	lockoutMax              = 24 * time.Hour
+)
+
+func emailThrottleKey(email string) string {
+	return "email:" + strings.ToLower(email)
+}
+
+// claimSignIn counts a sign in attempt against the account and the client
+// IP before the credentials are checked. If either is locked out, nothing is
+// counted and it returns nil claims and the end of the lockout.
+func (app *app) claimSignIn(emailKey, ipKey string) (account, ip *data.ThrottleClaim, lockedUntil time.Time, err error) {
+
+	account, err = app.models.Throttles.Claim(emailKey, accountLockoutThreshold, lockoutBase, lockoutMax)
+	if err != nil {
+		return nil, nil, time.Time{}, err
+	}
+	if account.Refused {
+		return nil, nil, account.LockedUntil, nil
+	}
+
+	ip, err = app.models.Throttles.Claim(ipKey, ipLockoutThreshold, lockoutBase, lockoutMax)
+	if err != nil {
+		return nil, nil, time.Time{}, err
+	}
+	if ip.Refused {
+		if err := app.models.Throttles.Release(account); err != nil {
+			return nil, nil, time.Time{}, err
+		}
+		return nil, nil, ip.LockedUntil, nil
+	}
+
+	return account, ip, time.Time{}, nil
+}
+
+func tooManyAttempts(c echo.Context, lockedUntil time.Time, message string) error {
+	retryAfter := int(time.Until(lockedUntil).Seconds()) + 1
+	if retryAfter < 1 {
+		retryAfter = 1
+	}
+	c.Response().Header().Set("Retry-After", strconv.Itoa(retryAfter))
+
+	return c.JSON(http.StatusTooManyRequests, map[string]string{
+		"message": message,
+	})
+}
+
+// failedSignIn responds to a sign in that failed after claimSignIn counted
+// it.
+func (app *app) failedSignIn(c echo.Context, user *data.User, account *data.ThrottleClaim) error {
+
+	app.notifyLockout(c, user, account)
+
+	return c.JSON(http.StatusUnauthorized, map[string]string{
+		"message": "invalid credentials",
+	})
+}
+
+// notifyLockout emails the owner of an account when a failed attempt starts
+// its lockout.
+func (app *app) notifyLockout(c echo.Context, user *data.User, account *data.ThrottleClaim) {
+
+	if user == nil || account.Failures != accountLockoutThreshold {
+		return
+	}
+
+	lockedUntil := account.LockedUntil
+	app.logger.Printf("account %d locked until %s after failed sign ins", user.ID, lockedUntil.Format(time.RFC3339))
+
+	// The echo context is recycled once the handler returns, so read
+	// everything the email needs up front.
+	ip := c.RealIP()
+
+	app.background(func() {
+		data := map[string]interface{}{
+			"lockedUntil": lockedUntil.UTC().Format(time.RFC1123),
+			"ip":          ip,
+		}
+
+		if err := app.mailer.Send(user.Email, "account_locked.tmpl", data); err != nil {
+			app.logger.Print(err)
+		}
+	})
+}
+
+// startSession signs a user in whose credentials have been checked. In the
+// default token mode it issues an opaque 24 hour authentication token; with
+// -auth-mode=jwt it issues a JWT access token and a refresh token instead.
+func (app *app) startSession(c echo.Context, user *data.User) error {
+
+	userAgent := requestUserAgent(c)
+
+	if app.config.auth.mode == authModeJWT {
+		refresh, err := app.models.Tokens.NewRefresh(user.ID, app.config.auth.refreshTTL, userAgent, c.RealIP())
 		if err != nil {
 			return c.JSON(http.StatusInternalServerError, map[string]string{
 				"message": "Internal Server Error",
 			})
 		}
+		return app.writeTokenPair(c, user, refresh)
+	}
+
+	token, err := app.models.Tokens.NewSession(user.ID, 24*time.Hour, userAgent, c.RealIP())
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{
+			"message": "Internal Server Error",
+		})
+	}
+
+	cookie := http.Cookie{
+		Name:     "token",
+		Value:    token.Plaintext,
+		Secure:   false,
+		Expires:  time.Now().Add(time.Hour * 24),
+		HttpOnly: true,
+		Path:     "/",
+	} //Creates the cookie to be passed.
+
+	c.SetCookie(&cookie)
+	return c.JSON(200, token)
+}
+
+// writeTokenPair responds with a fresh JWT access token alongside the given
+// refresh token, as both cookies and JSON.
+func (app *app) writeTokenPair(c echo.Context, user *data.User, refresh *data.Token) error {
+
+	permissions, err := app.models.Permissions.GetAllForUser(user.ID)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{
+			"message": "Internal Server Error",
+		})
+	}
+
+	access, accessExpiry, err := app.newAccessToken(user, permissions, refresh.Family)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{
+			"message": "Internal Server Error",
+		})
+	}
+
+	c.SetCookie(&http.Cookie{
+		Name:     "token",
+		Value:    access,
+		Secure:   false,
+		Expires:  accessExpiry,
+		HttpOnly: true,
+		Path:     "/",
+	})
+	c.SetCookie(&http.Cookie{
+		Name:     "refresh_token",
+		Value:    refresh.Plaintext,
+		Secure:   false,
+		Expires:  refresh.Expiry,
+		HttpOnly: true,
+		Path:     "/users/token",
+	})
+
+	return c.JSON(200, map[string]interface{}{
+		"access_token": map[string]interface{}{
+			"token":  access,
+			"expiry": accessExpiry,
+		},
+		"refresh_token": refresh,
+	})
+}
+
+func (app *app) refreshTokenHandler() func(c echo.Context) error {
+	return func(c echo.Context) error {
+
+		if app.config.auth.mode != authModeJWT {
+			return c.JSON(http.StatusNotFound, map[string]string{
+				"message": "refresh tokens are only issued when the API runs with -auth-mode=jwt",
+			})
+		}
+
+		var input struct {
+			RefreshToken string `json:"refresh_token"`
+		}
+
+		if err := c.Bind(&input); err != nil {
+			return c.JSON(http.StatusBadRequest, map[string]string{
+				"message": "Bad Request, verify json body",
+			})
+		}
+		if input.RefreshToken == "" {
+			if cookie, err := c.Cookie("refresh_token"); err == nil {
+				input.RefreshToken = cookie.Value
+			}
+		}
+		if !validTokenFormat(input.RefreshToken) {
+			return c.JSON(http.StatusUnauthorized, map[string]string{
+				"message": "missing or malformed refresh token",
+			})
+		}
+
+		refresh, err := app.models.Tokens.Rotate(input.RefreshToken, app.config.auth.refreshTTL, requestUserAgent(c), c.RealIP())
+		if err != nil {
+			switch {
+			case errors.Is(err, data.ErrRefreshTokenReused):
+				app.logger.Printf("refresh token reuse detected from %s", c.RealIP())
+				clearTokenCookie(c)
+				return c.JSON(http.StatusUnauthorized, map[string]string{
+					"message": "refresh token was already used, please sign in again",
+				})
+			case errors.Is(err, data.ErrRecordNotFound):
+				return c.JSON(http.StatusUnauthorized, map[string]string{
+					"message": "invalid or expired refresh token",
+				})
+			default:
+				return c.JSON(http.StatusInternalServerError, map[string]string{
+					"message": "Internal Server Error",
+				})
+			}
+		}
 
-		cookie := http.Cookie{
-			Name:     "token",
-			Value:    token.Plaintext,
-			Secure:   false,
-			Expires:  time.Now().Add(time.Hour * 24),
-			HttpOnly: true,
-			Path:     "/",
-		} //Creates the cookie to be passed.
+		user, err := app.models.Users.Get(refresh.UserId)
+		if err != nil {
+			return c.JSON(http.StatusInternalServerError, map[string]string{
+				"message": "Internal Server Error",
+			})
+		}
 
-		c.SetCookie(&cookie)
-		return c.JSON(200, token)
+		return app.writeTokenPair(c, user, refresh)
 	}
 }
 
 func (app *app) signOutHandler() func(c echo.Context) error {
+	return func(c echo.Context) error {
+
+		user := c.Get("user").(*data.User)
+		session := c.Get("session").(*data.Token)
+
+		var err error
+		if session.Family != "" {
+			err = app.models.Tokens.DeleteFamily(user.ID, session.Family)
+		} else {
+			err = app.models.Tokens.DeleteForUser(user.ID, session.ID, data.ScopeAuthentication)
+		}
+		if err != nil && !errors.Is(err, data.ErrRecordNotFound) {
+			return c.JSON(500, map[string]string{
+				"message": "Internal Server Error",
+			})
+		}
+
+		clearTokenCookie(c)
+		return c.JSON(200, map[string]string{
+			"message": "User Signed Out",
+		})
+	}
+}
+
+func (app *app) signOutEverywhereHandler() func(c echo.Context) error {
 	return func(c echo.Context) error {
 
 		user := c.Get("user").(*data.User)
 
-		err := app.models.Tokens.DeleteAllForUser(user.ID, data.ScopeAuthentication)
+		for _, scope := range []string{data.ScopeAuthentication, data.ScopeRefresh} {
+			if err := app.models.Tokens.DeleteAllForUser(user.ID, scope); err != nil {
+				return c.JSON(500, map[string]string{
+					"message": "Internal Server Error",
+				})
+			}
+		}
+
+		clearTokenCookie(c)
+		return c.JSON(200, map[string]string{
+			"message": "User Signed Out of every session",
+		})
+	}
+}
+
+func (app *app) listSessionsHandler() func(c echo.Context) error {
+	return func(c echo.Context) error {
+
+		user := c.Get("user").(*data.User)
+		session := c.Get("session").(*data.Token)
+
+		// In JWT mode a session is a refresh token family; the access token
+		// carries no row id of its own.
+		var sessions []*data.Session
+		var err error
+		if session.Family != "" {
+			sessions, err = app.models.Tokens.GetRefreshSessionsForUser(user.ID, session.Family)
+		} else {
+			sessions, err = app.models.Tokens.GetSessionsForUser(user.ID, session.ID)
+		}
 		if err != nil {
 			return c.JSON(500, map[string]string{
 				"message": "Internal Server Error",
 			})
 		}
 
-		cookie := http.Cookie{
-			Name:     "token",
-			Value:    "",
-			Secure:   false,
-			Expires:  time.Now().Add(-time.Hour),
-			HttpOnly: true,
-			Path:     "/",
+		return c.JSON(200, map[string]interface{}{
+			"sessions": sessions,
+		})
+	}
+}
+
+func (app *app) revokeSessionHandler() func(c echo.Context) error {
+	return func(c echo.Context) error {
+
+		sessionID, err := readIDParam(c, "id")
+		if err != nil {
+			return c.JSON(http.StatusBadRequest, map[string]string{
+				"message": err.Error(),
+			})
+		}
+
+		user := c.Get("user").(*data.User)
+		session := c.Get("session").(*data.Token)
+
+		current := sessionID == session.ID
+		if session.Family != "" {
+			var family string
+			family, err = app.models.Tokens.DeleteRefreshSession(user.ID, sessionID)
+			current = family == session.Family
+		} else {
+			err = app.models.Tokens.DeleteForUser(user.ID, sessionID, data.ScopeAuthentication)
+		}
+		if err != nil {
+			if errors.Is(err, data.ErrRecordNotFound) {
+				return c.JSON(404, map[string]string{
+					"message": "records not found",
+				})
+			}
+			return c.JSON(500, map[string]string{
+				"message": "Internal Server Error",
+			})
+		}
+
+		if current {
+			clearTokenCookie(c)
 		}
 
-		c.SetCookie(&cookie)
 		return c.JSON(200, map[string]string{
-			"message": "User Signed Out",
+			"message": "Session revoked",
 		})
 	}
 }
+
+func clearTokenCookie(c echo.Context) {
+	cookie := http.Cookie{
+		Name:     "token",
+		Value:    "",
+		Secure:   false,
+		Expires:  time.Now().Add(-time.Hour),
+		HttpOnly: true,
+		Path:     "/",
+	}
+
+	c.SetCookie(&cookie)
+
+	c.SetCookie(&http.Cookie{
+		Name:     "refresh_token",
+		Value:    "",
+		Secure:   false,
+		Expires:  time.Now().Add(-time.Hour),
+		HttpOnly: true,
+		Path:     "/users/token",
+	})
+}
+
+func requestUserAgent(c echo.Context) string {
+	userAgent := c.Request().UserAgent()
+	if len(userAgent) > 512 {
+		userAgent = userAgent[:512]
+	}
+	return userAgent
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/mayank12gt/movie-webapp/internal/data"
+)
+
+// maxTrackedViewers bounds the memory spent remembering who viewed what in
+// the current bucket. Past it, further views in the bucket aren't counted.
+const maxTrackedViewers = 1_000_000
+
+// trendingCounter counts movie activity in memory between flushes, so a busy
+// endpoint like GET /movies/:id costs a map increment rather than a write.
+type trendingCounter struct {
+	mu     sync.Mutex
+	counts map[data.TrendingKey]int
+
+	// viewed remembers the viewers of each movie during viewedBucket, so a
+	// client polling a movie counts as one view per bucket.
+	viewed       map[viewKey]struct{}
+	viewedBucket time.Time
+}
+
+type viewKey struct {
+	movieID int64
+	viewer  string
+}
+
+func newTrendingCounter() *trendingCounter {
+	return &trendingCounter{counts: make(map[data.TrendingKey]int), viewed: make(map[viewKey]struct{})}
+}
+
+func (t *trendingCounter) record(movieID int64, kind string) {
+	key := data.TrendingKey{MovieID: movieID, Kind: kind, Bucket: time.Now().Truncate(data.TrendingBucket)}
+
+	t.mu.Lock()
+	t.counts[key]++
+	t.mu.Unlock()
+}
+
+// recordView counts a view of a movie unless viewer already viewed it in the
+// current bucket.
+func (t *trendingCounter) recordView(movieID int64, viewer string) {
+	bucket := time.Now().Truncate(data.TrendingBucket)
+	key := data.TrendingKey{MovieID: movieID, Kind: data.TrendingView, Bucket: bucket}
+	seen := viewKey{movieID: movieID, viewer: viewer}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if !bucket.Equal(t.viewedBucket) {
+		t.viewed = make(map[viewKey]struct{})
+		t.viewedBucket = bucket
+	}
+	if _, ok := t.viewed[seen]; ok || len(t.viewed) >= maxTrackedViewers {
+		return
+	}
+	t.viewed[seen] = struct{}{}
+	t.counts[key]++
+}
+
+// take returns the counts so far and starts counting afresh.
+func (t *trendingCounter) take() map[data.TrendingKey]int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	counts := t.counts
+	t.counts = make(map[data.TrendingKey]int)
+	return counts
+}
+
+// restore adds back counts that couldn't be flushed, to be retried with the
+// next flush.
+func (t *trendingCounter) restore(counts map[data.TrendingKey]int) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	for key, count := range counts {
+		t.counts[key] += count
+	}
+}
+
+// recordActivity counts activity on a movie towards the trending chart.
+func (app *app) recordActivity(movieID int64, kind string) {
+	app.trending.record(movieID, kind)
+}
+
+// recordView counts a view of a movie towards the trending chart, at most
+// once per bucket for each signed in user or, for anonymous requests, each
+// IP address.
+func (app *app) recordView(c echo.Context, movieID int64) {
+	viewer := "ip:" + c.RealIP()
+	if userID := currentUserID(c); userID != 0 {
+		viewer = "user:" + strconv.FormatInt(userID, 10)
+	}
+	app.trending.recordView(movieID, viewer)
+}
+
+// flushTrending writes the counted activity to the database every interval
+// until stop is closed, and once more before shutdown completes.
+func (app *app) flushTrending(interval time.Duration, stop <-chan struct{}) {
+	app.background(func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-stop:
+				app.flushTrendingCounts()
+				return
+			case <-ticker.C:
+				app.flushTrendingCounts()
+			}
+		}
+	})
+}
+
+func (app *app) flushTrendingCounts() {
+	counts := app.trending.take()
+	if err := app.models.Trending.AddCounts(counts); err != nil {
+		app.logger.Printf("flushing trending counts: %v", err)
+		app.trending.restore(counts)
+	}
+}
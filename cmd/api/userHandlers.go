@@ -49,6 +49,15 @@ func (app *app) registerUserHandler() func(c echo.Context) error {
 			})
 		}
 
+		// A display name shows next to everything the user posts and can't
+		// wait in the moderation queue, so a name the filter would hold is
+		// rejected outright.
+		if status, _ := app.screen(user.Name); status != data.StatusPublished {
+			return c.JSON(422, map[string]string{
+				"message": "name contains words that are not allowed",
+			})
+		}
+
 		app.logger.Print(user)
 
 		if err := app.models.Users.Insert(user); err != nil {
@@ -152,3 +161,125 @@ func (app *app) activateUserHandler() func(c echo.Context) error {
 		})
 	}
 }
+
+func (app *app) createPasswordResetTokenHandler() func(c echo.Context) error {
+	return func(c echo.Context) error {
+		var input struct {
+			Email string `json:"email" validate:"required,email"`
+		}
+
+		if err := c.Bind(&input); err != nil {
+			return c.JSON(http.StatusBadRequest, map[string]string{
+				"message": "Bad Request, verify json body",
+			})
+		}
+
+		validate := validator.New()
+		if err := validate.Struct(input); err != nil {
+			return c.JSON(422, map[string]string{
+				"message": err.Error(),
+			})
+		}
+
+		// The lookup runs in the background so that neither the response nor its
+		// timing reveals whether the email address belongs to an account.
+		app.background(func() {
+			user, err := app.models.Users.GetByEmail(input.Email)
+			if err != nil {
+				if !errors.Is(err, sql.ErrNoRows) {
+					app.logger.Print(err)
+				}
+				return
+			}
+			if !user.Activated {
+				return
+			}
+
+			token, err := app.models.Tokens.New(user.ID, 45*time.Minute, data.ScopePasswordReset)
+			if err != nil {
+				app.logger.Print(err)
+				return
+			}
+
+			data := map[string]interface{}{
+				"passwordResetToken": token.Plaintext,
+			}
+
+			if err := app.mailer.Send(user.Email, "password_reset.tmpl", data); err != nil {
+				app.logger.Print(err)
+			}
+		})
+
+		return c.JSON(http.StatusAccepted, map[string]string{
+			"message": "if an activated account exists for this email, a password reset token has been sent to it",
+		})
+	}
+}
+
+func (app *app) resetPasswordHandler() func(c echo.Context) error {
+	return func(c echo.Context) error {
+		var input struct {
+			Password       string `json:"password" validate:"required,min=8,max=72"`
+			TokenPlainText string `json:"token" validate:"required,min=26"`
+		}
+
+		if err := c.Bind(&input); err != nil {
+			return c.JSON(http.StatusBadRequest, map[string]string{
+				"message": "Bad Request, verify json body",
+			})
+		}
+
+		validate := validator.New()
+		if err := validate.Struct(input); err != nil {
+			return c.JSON(422, map[string]string{
+				"message": err.Error(),
+			})
+		}
+
+		user, err := app.models.Users.GetForToken(data.ScopePasswordReset, input.TokenPlainText)
+		if err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				return c.JSON(422, map[string]string{
+					"message": "invalid or expired password reset token",
+				})
+			}
+			return c.JSON(http.StatusInternalServerError, map[string]string{
+				"message": "Internal Server Error",
+			})
+		}
+
+		if err := user.Password.Set(input.Password); err != nil {
+			return c.JSON(422, map[string]string{
+				"message": err.Error(),
+			})
+		}
+
+		if err := app.models.Users.Update(user); err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				return c.JSON(http.StatusConflict, map[string]string{
+					"message": "user was modified concurrently, please retry",
+				})
+			}
+			return c.JSON(http.StatusInternalServerError, map[string]string{
+				"message": "Internal Server Error",
+			})
+		}
+
+		// The reset token is single use, and nothing obtained with the old
+		// password may outlive it: sessions, JWT refresh tokens, sign ins
+		// waiting for a second factor, and API keys, which whoever knew the
+		// old password could have created.
+		scopes := []string{data.ScopePasswordReset, data.ScopeAuthentication, data.ScopeRefresh, data.ScopeMFAPending, data.ScopeAPIKey}
+		for _, scope := range scopes {
+			if err := app.models.Tokens.DeleteAllForUser(user.ID, scope); err != nil {
+				return c.JSON(http.StatusInternalServerError, map[string]string{
+					"message": "Internal Server Error",
+				})
+			}
+		}
+
+		return c.JSON(200, map[string]string{
+			"message": "password updated, please sign in again",
+		})
+	}
+}
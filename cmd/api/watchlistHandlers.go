@@ -0,0 +1,124 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/go-playground/validator/v10"
+	"github.com/labstack/echo/v4"
+	"github.com/mayank12gt/movie-webapp/internal/data"
+)
+
+var watchlistSortSafelist = append([]string{"added", "-added"}, movieSortSafelist...)
+
+func (app *app) listWatchlistHandler() func(c echo.Context) error {
+	return func(c echo.Context) error {
+		genres := []string{}
+		if c.QueryParam("genres") != "" {
+			genres = strings.Split(c.QueryParam("genres"), ",")
+		}
+
+		filters, err := readFilters(c, "-added", watchlistSortSafelist)
+		if err != nil {
+			return c.JSON(http.StatusBadRequest, map[string]string{
+				"message": err.Error(),
+			})
+		}
+
+		validate := validator.New()
+		if err := validate.Struct(filters); err != nil {
+			return c.JSON(422, map[string]string{
+				"message": err.Error(),
+			})
+		}
+		if !filters.ValidSort() {
+			return c.JSON(422, map[string]string{
+				"message": "sort must be one of " + strings.Join(watchlistSortSafelist, " "),
+			})
+		}
+
+		user := c.Get("user").(*data.User)
+
+		entries, meta, err := app.models.Watchlist.List(user.ID, genres, filters)
+		if err != nil {
+			return c.JSON(500, map[string]string{
+				"message": "Internal Server Error",
+			})
+		}
+
+		movies := make([]*data.Movie, len(entries))
+		for i, entry := range entries {
+			movies[i] = entry.Movie
+		}
+		if err := app.loadImages(movies...); err != nil {
+			return c.JSON(500, map[string]string{
+				"message": "Internal Server Error",
+			})
+		}
+
+		return c.JSON(200, map[string]interface{}{
+			"metadata":  meta,
+			"watchlist": entries,
+		})
+	}
+}
+
+func (app *app) addToWatchlistHandler() func(c echo.Context) error {
+	return func(c echo.Context) error {
+		movieID, err := readIDParam(c, "movie_id")
+		if err != nil {
+			return c.JSON(http.StatusBadRequest, map[string]string{
+				"message": err.Error(),
+			})
+		}
+
+		user := c.Get("user").(*data.User)
+
+		entry, added, err := app.models.Watchlist.Add(user.ID, movieID)
+		if err != nil {
+			if errors.Is(err, data.ErrRecordNotFound) {
+				return c.JSON(404, map[string]string{
+					"message": "records not found",
+				})
+			}
+			return c.JSON(500, map[string]string{
+				"message": "Internal Server Error",
+			})
+		}
+
+		if added {
+			app.recordActivity(movieID, data.TrendingWatchlist)
+		}
+
+		return c.JSON(200, entry)
+	}
+}
+
+func (app *app) removeFromWatchlistHandler() func(c echo.Context) error {
+	return func(c echo.Context) error {
+		movieID, err := readIDParam(c, "movie_id")
+		if err != nil {
+			return c.JSON(http.StatusBadRequest, map[string]string{
+				"message": err.Error(),
+			})
+		}
+
+		user := c.Get("user").(*data.User)
+
+		if err := app.models.Watchlist.Remove(user.ID, movieID); err != nil {
+			if errors.Is(err, data.ErrRecordNotFound) {
+				return c.JSON(404, map[string]string{
+					"message": "records not found",
+				})
+			}
+			return c.JSON(500, map[string]string{
+				"message": "Internal Server Error",
+			})
+		}
+
+		return c.JSON(200, map[string]string{
+			"message": "Removed from watchlist",
+		})
+	}
+}
@@ -0,0 +1,127 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"time"
+)
+
+// Kinds of activity shown in the feed.
+const (
+	EventRating = "rating"
+	EventReview = "review"
+	EventDiary  = "diary"
+	EventList   = "list"
+)
+
+// Event is something a user did, shown in their followers' feeds. Which of
+// the optional fields are set depends on Kind.
+type Event struct {
+	ID           int64     `json:"id"`
+	UserID       int64     `json:"user_id"`
+	UserName     string    `json:"user_name"`
+	Kind         string    `json:"kind"`
+	MovieID      *int64    `json:"movie_id,omitempty"`
+	MovieTitle   string    `json:"movie_title,omitempty"`
+	ListID       *int64    `json:"list_id,omitempty"`
+	ListName     string    `json:"list_name,omitempty"`
+	DiaryEntryID *int64    `json:"diary_entry_id,omitempty"`
+	Rating       *float64  `json:"rating,omitempty"`
+	ReviewTitle  string    `json:"review_title,omitempty"`
+	CreatedAt    time.Time `json:"created_at"`
+}
+
+type execer interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+}
+
+// recordEvent stores an event as part of the write that caused it, so the
+// feed never shows activity that was rolled back.
+func recordEvent(ctx context.Context, db execer, event *Event) error {
+
+	query := `INSERT INTO activity_events (user_id, kind, movie_id, list_id, diary_entry_id, rating) VALUES ($1, $2, $3, $4, $5, $6)`
+
+	_, err := db.ExecContext(ctx, query, event.UserID, event.Kind, event.MovieID, event.ListID, event.DiaryEntryID, event.Rating)
+	return err
+}
+
+type ActivityModel struct {
+	DB *sql.DB
+}
+
+// Feed returns the newest activity of the users userID follows, older than
+// the event id before when it is non-zero. Reviews and lists are left out
+// while they are held, hidden or private. The next page starts before the
+// last returned event.
+func (m ActivityModel) Feed(userID, before int64, limit int) ([]*Event, error) {
+
+	query := `SELECT activity_events.id, activity_events.user_id, users.name, activity_events.kind,
+	activity_events.movie_id, COALESCE(movies.title, ''), activity_events.list_id, COALESCE(lists.name, ''),
+	activity_events.diary_entry_id, activity_events.rating, COALESCE(reviews.title, ''), activity_events.created_at
+	FROM activity_events
+	INNER JOIN follows ON follows.followee_id = activity_events.user_id AND follows.follower_id = $1 AND follows.status = 'accepted'
+	INNER JOIN users ON users.id = activity_events.user_id
+	LEFT JOIN movies ON movies.id = activity_events.movie_id
+	LEFT JOIN lists ON lists.id = activity_events.list_id
+	LEFT JOIN reviews ON activity_events.kind = 'review' AND reviews.user_id = activity_events.user_id AND reviews.movie_id = activity_events.movie_id
+	WHERE ($2 = 0 OR activity_events.id < $2)
+	AND (activity_events.kind <> 'review' OR reviews.status = 'published')
+	AND (activity_events.kind <> 'list' OR (lists.public AND lists.status = 'published'))
+	ORDER BY activity_events.id DESC
+	LIMIT $3`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, userID, before, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	events := []*Event{}
+
+	for rows.Next() {
+		var event Event
+		var movieID, listID, diaryEntryID sql.NullInt64
+		var rating sql.NullFloat64
+
+		err := rows.Scan(
+			&event.ID,
+			&event.UserID,
+			&event.UserName,
+			&event.Kind,
+			&movieID,
+			&event.MovieTitle,
+			&listID,
+			&event.ListName,
+			&diaryEntryID,
+			&rating,
+			&event.ReviewTitle,
+			&event.CreatedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+		if movieID.Valid {
+			event.MovieID = &movieID.Int64
+		}
+		if listID.Valid {
+			event.ListID = &listID.Int64
+		}
+		if diaryEntryID.Valid {
+			event.DiaryEntryID = &diaryEntryID.Int64
+		}
+		if rating.Valid {
+			event.Rating = &rating.Float64
+		}
+
+		events = append(events, &event)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return events, nil
+}
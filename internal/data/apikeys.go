@@ -0,0 +1,114 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// APIKey is the client-facing view of an api-key token. Key is only filled
+// in when the key is created; it cannot be recovered afterwards.
+type APIKey struct {
+	ID          int64       `json:"id"`
+	Name        string      `json:"name"`
+	Key         string      `json:"key,omitempty"`
+	Permissions Permissions `json:"permissions"`
+	CreatedAt   time.Time   `json:"created_at"`
+	LastUsedAt  time.Time   `json:"last_used_at"`
+	Expiry      *time.Time  `json:"expiry"`
+}
+
+// NewAPIKey creates a long-lived token restricted to the given permission
+// codes. A nil expiry creates a key that never expires.
+func (T *TokenModel) NewAPIKey(userID int64, name string, expiry *time.Time, codes Permissions) (*APIKey, error) {
+
+	token, err := generateToken(userID, 0, ScopeAPIKey)
+	if err != nil {
+		return nil, err
+	}
+	token.Name = name
+	token.Expiry = time.Time{}
+	if expiry != nil {
+		token.Expiry = *expiry
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	tx, err := T.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	if err := insertToken(ctx, tx, token); err != nil {
+		return nil, err
+	}
+
+	query := `INSERT INTO tokens_permissions SELECT $1, permissions.id FROM permissions WHERE permissions.code = ANY($2)`
+
+	if _, err := tx.ExecContext(ctx, query, token.ID, pq.Array(codes)); err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	return &APIKey{
+		ID:          token.ID,
+		Name:        token.Name,
+		Key:         token.Plaintext,
+		Permissions: codes,
+		CreatedAt:   token.CreatedAt,
+		LastUsedAt:  token.CreatedAt,
+		Expiry:      expiry,
+	}, nil
+}
+
+// GetAPIKeysForUser lists a user's unexpired API keys, newest first.
+func (T *TokenModel) GetAPIKeysForUser(userID int64) ([]*APIKey, error) {
+
+	query := `SELECT tokens.id, tokens.name, tokens.created_at, tokens.last_used_at, tokens.expiry,
+	COALESCE(array_agg(permissions.code ORDER BY permissions.code) FILTER (WHERE permissions.code IS NOT NULL), '{}')
+	FROM tokens
+	LEFT JOIN tokens_permissions ON tokens_permissions.token_id = tokens.id
+	LEFT JOIN permissions ON permissions.id = tokens_permissions.permission_id
+	WHERE tokens.user_id = $1 AND tokens.scope = $2 AND (tokens.expiry IS NULL OR tokens.expiry > $3)
+	GROUP BY tokens.id
+	ORDER BY tokens.created_at DESC, tokens.id DESC`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := T.DB.QueryContext(ctx, query, userID, ScopeAPIKey, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	keys := []*APIKey{}
+	for rows.Next() {
+		var key APIKey
+		var expiry sql.NullTime
+		var codes []string
+
+		err := rows.Scan(&key.ID, &key.Name, &key.CreatedAt, &key.LastUsedAt, &expiry, pq.Array(&codes))
+		if err != nil {
+			return nil, err
+		}
+		if expiry.Valid {
+			key.Expiry = &expiry.Time
+		}
+		key.Permissions = Permissions(codes)
+
+		keys = append(keys, &key)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return keys, nil
+}
@@ -0,0 +1,98 @@
+package data
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// RatingWeighting configures the IMDb style Bayesian weighted rating
+//
+//	WR = (v*R + m*C) / (v + m)
+//
+// where R and v are a movie's average rating and rating count, m is MinVotes
+// and C is Mean. A movie with few ratings is pulled towards C, so a single
+// 10 can't outrank a classic rated by thousands.
+type RatingWeighting struct {
+	MinVotes int
+	// Mean is the rating assumed for movies without ratings. When zero, the
+	// mean of all ratings is used.
+	Mean float64
+}
+
+var DefaultRatingWeighting = RatingWeighting{MinVotes: 25}
+
+// expression returns the weighted rating of a row of movies. v*R is simply
+// the movie's rating_sum.
+func (w RatingWeighting) expression() string {
+	minVotes := w.MinVotes
+	if minVotes < 1 {
+		minVotes = 1
+	}
+
+	mean := "(SELECT COALESCE(sum(rating_sum) / NULLIF(sum(rating_count), 0), 0) FROM movies)"
+	if w.Mean > 0 {
+		mean = strconv.FormatFloat(w.Mean, 'f', -1, 64)
+	}
+
+	return fmt.Sprintf("((movies.rating_sum + %d * %s) / (movies.rating_count + %d))", minVotes, mean, minVotes)
+}
+
+// movieAverageRating is a movie's plain average rating.
+const movieAverageRating = "COALESCE(movies.rating_sum / NULLIF(movies.rating_count, 0), 0)"
+
+// TopRated pages through the movies with at least minVotes ratings, ranked
+// by weighted rating, optionally limited to a genre.
+func (m MovieModel) TopRated(genre string, minVotes int, filters Filters) ([]*Movie, Metadata, error) {
+
+	query := fmt.Sprintf(`SELECT count(*) OVER(), movies.id, movies.created_at, movies.title, movies.year, movies.runtime, movies.genres, movies.version,
+	%s, movies.rating_count, %s AS weighted_rating
+	FROM movies
+	WHERE movies.rating_count >= $1 AND ($2 = '' OR $2 = ANY(movies.genres))
+	ORDER BY weighted_rating DESC, movies.rating_count DESC, movies.id ASC LIMIT $3 OFFSET $4`,
+		movieAverageRating, m.Weighting.expression())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, minVotes, genre, filters.limit(), filters.offset())
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	defer rows.Close()
+
+	movies := []*Movie{}
+	totalRecords := 0
+
+	for rows.Next() {
+		var movie Movie
+
+		err := rows.Scan(
+			&totalRecords,
+			&movie.ID,
+			&movie.CreatedAt,
+			&movie.Title,
+			&movie.Year,
+			&movie.Runtime,
+			pq.Array(&movie.Genres),
+			&movie.Version,
+			&movie.AverageRating,
+			&movie.RatingCount,
+			&movie.WeightedRating,
+		)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+
+		movies = append(movies, &movie)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	return movies, calculateMetadata(totalRecords, filters.Page, filters.PageSize), nil
+}
@@ -0,0 +1,133 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"time"
+)
+
+const (
+	RoleActor    = "actor"
+	RoleDirector = "director"
+	RoleWriter   = "writer"
+	RoleComposer = "composer"
+)
+
+// Credit links a person to a movie in a given role. Character and
+// BillingOrder only carry meaning for actors.
+type Credit struct {
+	ID           int64  `json:"id"`
+	MovieID      int64  `json:"movie_id"`
+	PersonID     int64  `json:"person_id" validate:"required,min=1"`
+	Role         string `json:"role" validate:"required,oneof=actor director writer composer"`
+	Character    string `json:"character,omitempty" validate:"max=200"`
+	BillingOrder int32  `json:"billing_order,omitempty" validate:"min=0"`
+	PersonName   string `json:"person_name,omitempty"`
+	MovieTitle   string `json:"movie_title,omitempty"`
+	MovieYear    int32  `json:"movie_year,omitempty"`
+}
+
+type CreditModel struct {
+	DB *sql.DB
+}
+
+func (m CreditModel) Insert(credit *Credit) error {
+
+	query := `INSERT INTO credits (movie_id, person_id, role, character, billing_order)
+	VALUES ($1, $2, $3, $4, $5) RETURNING id`
+
+	args := []interface{}{credit.MovieID, credit.PersonID, credit.Role, credit.Character, credit.BillingOrder}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	return m.DB.QueryRowContext(ctx, query, args...).Scan(&credit.ID)
+}
+
+func (m CreditModel) Delete(movieID, creditID int64) error {
+
+	query := `DELETE FROM credits WHERE id = $1 AND movie_id = $2`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, creditID, movieID)
+	if err != nil {
+		return err
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
+
+// GetAllForMovie returns the cast in billing order followed by the crew.
+func (m CreditModel) GetAllForMovie(movieID int64) ([]*Credit, error) {
+
+	query := `SELECT credits.id, credits.movie_id, credits.person_id, credits.role, credits.character, credits.billing_order, people.name
+	FROM credits INNER JOIN people ON people.id = credits.person_id
+	WHERE credits.movie_id = $1
+	ORDER BY credits.role <> 'actor', credits.billing_order, credits.role, people.name`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, movieID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	credits := []*Credit{}
+	for rows.Next() {
+		var credit Credit
+		err := rows.Scan(&credit.ID, &credit.MovieID, &credit.PersonID, &credit.Role, &credit.Character, &credit.BillingOrder, &credit.PersonName)
+		if err != nil {
+			return nil, err
+		}
+		credits = append(credits, &credit)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return credits, nil
+}
+
+// GetAllForPerson returns a person's filmography, newest movies first.
+func (m CreditModel) GetAllForPerson(personID int64) ([]*Credit, error) {
+
+	query := `SELECT credits.id, credits.movie_id, credits.person_id, credits.role, credits.character, credits.billing_order, movies.title, movies.year
+	FROM credits INNER JOIN movies ON movies.id = credits.movie_id
+	WHERE credits.person_id = $1
+	ORDER BY movies.year DESC, movies.title, credits.role`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, personID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	credits := []*Credit{}
+	for rows.Next() {
+		var credit Credit
+		err := rows.Scan(&credit.ID, &credit.MovieID, &credit.PersonID, &credit.Role, &credit.Character, &credit.BillingOrder, &credit.MovieTitle, &credit.MovieYear)
+		if err != nil {
+			return nil, err
+		}
+		credits = append(credits, &credit)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return credits, nil
+}
@@ -0,0 +1,238 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// DateLayout is the format of calendar dates such as watched_on.
+const DateLayout = "2006-01-02"
+
+// DiaryEntry records one viewing of a movie. A user can log the same movie
+// any number of times; Rating is what they thought of it that time, and
+// doesn't change their rating of the movie.
+type DiaryEntry struct {
+	ID         int64     `json:"id"`
+	MovieID    int64     `json:"movie_id" validate:"required,min=1"`
+	MovieTitle string    `json:"movie_title,omitempty"`
+	WatchedOn  string    `json:"watched_on" validate:"required,datetime=2006-01-02"`
+	Rewatch    bool      `json:"rewatch"`
+	Rating     *float64  `json:"rating"`
+	Note       string    `json:"note" validate:"max=2000"`
+	CreatedAt  time.Time `json:"created_at"`
+}
+
+// DiaryYear summarises a user's viewing in one calendar year.
+type DiaryYear struct {
+	Year         int          `json:"year"`
+	Entries      int          `json:"entries"`
+	FilmsWatched int          `json:"films_watched"`
+	Rewatches    int          `json:"rewatches"`
+	TotalRuntime int          `json:"total_runtime"`
+	TopGenres    []GenreCount `json:"top_genres"`
+}
+
+type GenreCount struct {
+	Genre string `json:"genre"`
+	Count int    `json:"count"`
+}
+
+// diaryTopGenres is how many genres DiaryYear.TopGenres lists.
+const diaryTopGenres = 5
+
+type DiaryModel struct {
+	DB *sql.DB
+}
+
+// Insert logs a viewing. When rewatch is nil, the entry counts as a rewatch
+// if the user logged the movie on or before the same date. It returns
+// ErrRecordNotFound when the movie doesn't exist.
+func (m DiaryModel) Insert(userID int64, entry *DiaryEntry, rewatch *bool) error {
+
+	query := `INSERT INTO diary_entries (user_id, movie_id, watched_on, rewatch, rating, note)
+	VALUES ($1, $2, $3::date, COALESCE($4, EXISTS (SELECT 1 FROM diary_entries WHERE user_id = $1 AND movie_id = $2 AND watched_on <= $3::date)), $5, $6)
+	RETURNING id, rewatch, created_at, (SELECT title FROM movies WHERE id = $2)`
+
+	args := []interface{}{userID, entry.MovieID, entry.WatchedOn, rewatch, entry.Rating, entry.Note}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var title sql.NullString
+	err = tx.QueryRowContext(ctx, query, args...).Scan(&entry.ID, &entry.Rewatch, &entry.CreatedAt, &title)
+	if err != nil {
+		var pqErr *pq.Error
+		if errors.As(err, &pqErr) && pqErr.Code.Name() == "foreign_key_violation" {
+			return ErrRecordNotFound
+		}
+		return err
+	}
+	entry.MovieTitle = title.String
+
+	event := &Event{UserID: userID, Kind: EventDiary, MovieID: &entry.MovieID, DiaryEntryID: &entry.ID, Rating: entry.Rating}
+	if err := recordEvent(ctx, tx, event); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func (m DiaryModel) Delete(userID, entryID int64) error {
+
+	query := `DELETE FROM diary_entries WHERE id = $1 AND user_id = $2`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, entryID, userID)
+	if err != nil {
+		return err
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
+
+// List pages through a user's diary, optionally between two dates
+// (inclusive, either may be empty).
+func (m DiaryModel) List(userID int64, from, to string, filters Filters) ([]*DiaryEntry, Metadata, error) {
+
+	query := fmt.Sprintf(`SELECT count(*) OVER(), diary_entries.id, diary_entries.movie_id, movies.title, diary_entries.watched_on,
+	diary_entries.rewatch, diary_entries.rating, diary_entries.note, diary_entries.created_at
+	FROM diary_entries
+	INNER JOIN movies ON movies.id = diary_entries.movie_id
+	WHERE diary_entries.user_id = $1
+	AND ($2 = '' OR diary_entries.watched_on >= $2::date) AND ($3 = '' OR diary_entries.watched_on <= $3::date)
+	ORDER BY diary_entries.watched_on %s, diary_entries.id %s LIMIT $4 OFFSET $5`, filters.sortDirection(), filters.sortDirection())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, userID, from, to, filters.limit(), filters.offset())
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	defer rows.Close()
+
+	entries := []*DiaryEntry{}
+	totalRecords := 0
+
+	for rows.Next() {
+		var entry DiaryEntry
+		var watchedOn time.Time
+		var rating sql.NullFloat64
+
+		err := rows.Scan(
+			&totalRecords,
+			&entry.ID,
+			&entry.MovieID,
+			&entry.MovieTitle,
+			&watchedOn,
+			&entry.Rewatch,
+			&rating,
+			&entry.Note,
+			&entry.CreatedAt,
+		)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+		entry.WatchedOn = watchedOn.Format(DateLayout)
+		if rating.Valid {
+			entry.Rating = &rating.Float64
+		}
+
+		entries = append(entries, &entry)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	return entries, calculateMetadata(totalRecords, filters.Page, filters.PageSize), nil
+}
+
+// YearlyStats summarises a user's diary per year, most recent first. A
+// non-zero year limits it to that year.
+func (m DiaryModel) YearlyStats(userID int64, year int) ([]*DiaryYear, error) {
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	query := `SELECT extract(year FROM diary_entries.watched_on)::int AS year, count(*), count(DISTINCT diary_entries.movie_id),
+	count(*) FILTER (WHERE diary_entries.rewatch), COALESCE(sum(movies.runtime), 0)
+	FROM diary_entries
+	INNER JOIN movies ON movies.id = diary_entries.movie_id
+	WHERE diary_entries.user_id = $1 AND ($2 = 0 OR extract(year FROM diary_entries.watched_on) = $2)
+	GROUP BY year
+	ORDER BY year DESC`
+
+	rows, err := m.DB.QueryContext(ctx, query, userID, year)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	years := []*DiaryYear{}
+	byYear := map[int]*DiaryYear{}
+
+	for rows.Next() {
+		stats := DiaryYear{TopGenres: []GenreCount{}}
+		if err := rows.Scan(&stats.Year, &stats.Entries, &stats.FilmsWatched, &stats.Rewatches, &stats.TotalRuntime); err != nil {
+			return nil, err
+		}
+		years = append(years, &stats)
+		byYear[stats.Year] = &stats
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	// Genres count every viewing, so a rewatched favourite counts each time.
+	query = `SELECT year, genre, count(*) FROM (
+		SELECT extract(year FROM diary_entries.watched_on)::int AS year, unnest(movies.genres) AS genre
+		FROM diary_entries
+		INNER JOIN movies ON movies.id = diary_entries.movie_id
+		WHERE diary_entries.user_id = $1 AND ($2 = 0 OR extract(year FROM diary_entries.watched_on) = $2)
+	) AS genres
+	GROUP BY year, genre
+	ORDER BY year DESC, count(*) DESC, genre ASC`
+
+	rows, err = m.DB.QueryContext(ctx, query, userID, year)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var year int
+		var genre GenreCount
+		if err := rows.Scan(&year, &genre.Genre, &genre.Count); err != nil {
+			return nil, err
+		}
+		if stats, ok := byYear[year]; ok && len(stats.TopGenres) < diaryTopGenres {
+			stats.TopGenres = append(stats.TopGenres, genre)
+		}
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return years, nil
+}
@@ -6,9 +6,10 @@ import (
 )
 
 type Filters struct {
-	Page     int    `validate:"min=1,max=10000"`
-	PageSize int    `validate:"min=1,max=100"`
-	Sort     string `validate:"oneof=id title year runtime -id -title -year -runtime"`
+	Page         int      `validate:"min=1,max=10000"`
+	PageSize     int      `validate:"min=1,max=100"`
+	Sort         string   `validate:"required"`
+	SortSafelist []string `validate:"-"`
 }
 type Metadata struct {
 	CurrentPage  int `json:"current_page,omitempty"`
@@ -32,8 +33,23 @@ func calculateMetadata(totalRecords, page, pageSize int) Metadata {
 	}
 }
 
-func (f *Filters) sortColumn() {
+// ValidSort reports whether the requested sort value is in the safelist.
+// Handlers must check it before the filters reach a model, since the sort
+// column is interpolated into the query.
+func (f Filters) ValidSort() bool {
+	for _, safeValue := range f.SortSafelist {
+		if f.Sort == safeValue {
+			return true
+		}
+	}
+	return false
+}
 
+func (f Filters) sortColumn() string {
+	if !f.ValidSort() {
+		panic("unsafe sort parameter: " + f.Sort)
+	}
+	return strings.TrimPrefix(f.Sort, "-")
 }
 
 func (f Filters) sortDirection() string {
@@ -0,0 +1,205 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+)
+
+var (
+	ErrSelfFollow = errors.New("cannot follow yourself")
+)
+
+// Follow statuses. Following a private account creates a pending request
+// that the account owner has to approve.
+const (
+	FollowPending  = "pending"
+	FollowAccepted = "accepted"
+)
+
+// Follower is a user on the other end of a follow.
+type Follower struct {
+	ID     int64     `json:"id"`
+	Name   string    `json:"name"`
+	Status string    `json:"status,omitempty"`
+	Since  time.Time `json:"since"`
+}
+
+type FollowModel struct {
+	DB *sql.DB
+}
+
+// Follow makes followerID follow followeeID, or asks to when the followee's
+// account is private. It returns the resulting status; following someone
+// again leaves the existing follow or request as it is.
+func (m FollowModel) Follow(followerID, followeeID int64) (string, error) {
+	if followerID == followeeID {
+		return "", ErrSelfFollow
+	}
+
+	query := `WITH followee AS (SELECT id, private FROM users WHERE id = $2),
+	inserted AS (
+		INSERT INTO follows (follower_id, followee_id, status, accepted_at)
+		SELECT $1, followee.id, CASE WHEN followee.private THEN 'pending' ELSE 'accepted' END, CASE WHEN followee.private THEN NULL ELSE NOW() END
+		FROM followee
+		ON CONFLICT (follower_id, followee_id) DO NOTHING
+		RETURNING status
+	)
+	SELECT status FROM inserted
+	UNION ALL
+	SELECT status FROM follows WHERE follower_id = $1 AND followee_id = $2`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var status string
+	err := m.DB.QueryRowContext(ctx, query, followerID, followeeID).Scan(&status)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return "", ErrRecordNotFound
+		}
+		return "", err
+	}
+
+	return status, nil
+}
+
+// Unfollow removes a follow or cancels a pending request.
+func (m FollowModel) Unfollow(followerID, followeeID int64) error {
+	return m.exec(`DELETE FROM follows WHERE follower_id = $1 AND followee_id = $2`, followerID, followeeID)
+}
+
+// Reject declines a pending follow request to ownerID.
+func (m FollowModel) Reject(ownerID, followerID int64) error {
+	return m.exec(`DELETE FROM follows WHERE follower_id = $1 AND followee_id = $2 AND status = 'pending'`, followerID, ownerID)
+}
+
+// exec runs a statement that must change a row, returning ErrRecordNotFound
+// when it changes none.
+func (m FollowModel) exec(query string, args ...interface{}) error {
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, args...)
+	if err != nil {
+		return err
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
+
+// Approve accepts a pending follow request to ownerID.
+func (m FollowModel) Approve(ownerID, followerID int64) error {
+	return m.exec(`UPDATE follows SET status = 'accepted', accepted_at = NOW()
+	WHERE follower_id = $1 AND followee_id = $2 AND status = 'pending'`, followerID, ownerID)
+}
+
+// SetPrivate changes whether a user's account is private. Making an account
+// public accepts every pending request to it.
+func (m FollowModel) SetPrivate(userID int64, private bool) error {
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, `UPDATE users SET private = $2 WHERE id = $1`, userID, private); err != nil {
+		return err
+	}
+
+	if !private {
+		query := `UPDATE follows SET status = 'accepted', accepted_at = NOW() WHERE followee_id = $1 AND status = 'pending'`
+		if _, err := tx.ExecContext(ctx, query, userID); err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// CanView reports whether viewerID may see who userID follows and is
+// followed by: anyone can for public accounts, only accepted followers and
+// the owner for private ones. It returns ErrRecordNotFound for unknown users.
+func (m FollowModel) CanView(viewerID, userID int64) (bool, error) {
+
+	query := `SELECT NOT users.private OR users.id = $2
+	OR EXISTS (SELECT 1 FROM follows WHERE follower_id = $2 AND followee_id = users.id AND status = 'accepted')
+	FROM users WHERE users.id = $1`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var allowed bool
+	if err := m.DB.QueryRowContext(ctx, query, userID, viewerID).Scan(&allowed); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return false, ErrRecordNotFound
+		}
+		return false, err
+	}
+
+	return allowed, nil
+}
+
+// Followers pages through the users following userID.
+func (m FollowModel) Followers(userID int64, filters Filters) ([]*Follower, Metadata, error) {
+	return m.list("follows.follower_id", "follows.followee_id", FollowAccepted, userID, filters)
+}
+
+// Following pages through the users userID follows.
+func (m FollowModel) Following(userID int64, filters Filters) ([]*Follower, Metadata, error) {
+	return m.list("follows.followee_id", "follows.follower_id", FollowAccepted, userID, filters)
+}
+
+// Requests pages through the pending follow requests to userID.
+func (m FollowModel) Requests(userID int64, filters Filters) ([]*Follower, Metadata, error) {
+	return m.list("follows.follower_id", "follows.followee_id", FollowPending, userID, filters)
+}
+
+func (m FollowModel) list(otherColumn, userColumn, status string, userID int64, filters Filters) ([]*Follower, Metadata, error) {
+
+	query := fmt.Sprintf(`SELECT count(*) OVER(), users.id, users.name, follows.status, COALESCE(follows.accepted_at, follows.created_at)
+	FROM follows
+	INNER JOIN users ON users.id = %s
+	WHERE %s = $1 AND follows.status = $2
+	ORDER BY COALESCE(follows.accepted_at, follows.created_at) DESC, users.id DESC LIMIT $3 OFFSET $4`, otherColumn, userColumn)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, userID, status, filters.limit(), filters.offset())
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	defer rows.Close()
+
+	followers := []*Follower{}
+	totalRecords := 0
+
+	for rows.Next() {
+		var follower Follower
+		if err := rows.Scan(&totalRecords, &follower.ID, &follower.Name, &follower.Status, &follower.Since); err != nil {
+			return nil, Metadata{}, err
+		}
+		followers = append(followers, &follower)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	return followers, calculateMetadata(totalRecords, filters.Page, filters.PageSize), nil
+}
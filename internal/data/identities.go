@@ -0,0 +1,136 @@
+package data
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// Identity links a user to an account at an external OpenID Connect
+// provider, identified by the provider's issuer and subject.
+type Identity struct {
+	ID        int64     `json:"id"`
+	UserID    int64     `json:"user_id"`
+	Issuer    string    `json:"issuer"`
+	Subject   string    `json:"subject"`
+	Email     string    `json:"email"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type IdentityModel struct {
+	DB *sql.DB
+}
+
+// GetUser returns the user linked to an external identity.
+func (m IdentityModel) GetUser(issuer, subject string) (*User, error) {
+
+	query := `SELECT users.id, users.created_at, users.name, users.email, users.password_hash, users.activated, users.version
+	FROM users INNER JOIN user_identities ON user_identities.user_id = users.id
+	WHERE user_identities.issuer = $1 AND user_identities.subject = $2`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var user User
+	err := m.DB.QueryRowContext(ctx, query, issuer, subject).Scan(
+		&user.ID,
+		&user.CreatedAt,
+		&user.Name,
+		&user.Email,
+		&user.Password.Hash,
+		&user.Activated,
+		&user.Version,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return &user, nil
+}
+
+func (m IdentityModel) Insert(identity *Identity) error {
+
+	query := `INSERT INTO user_identities (user_id, issuer, subject, email) VALUES ($1, $2, $3, $4) RETURNING id, created_at`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	return m.DB.QueryRowContext(ctx, query, identity.UserID, identity.Issuer, identity.Subject, identity.Email).Scan(&identity.ID, &identity.CreatedAt)
+}
+
+// InsertWithUser creates a user, grants it the given permissions and links
+// the identity to it, all or nothing.
+func (m IdentityModel) InsertWithUser(user *User, identity *Identity, codes ...string) error {
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	query := `INSERT INTO users (name, email, password_hash, activated) VALUES ($1, $2, $3, $4) RETURNING id, created_at, version`
+
+	err = tx.QueryRowContext(ctx, query, user.Name, user.Email, user.Password.Hash, user.Activated).Scan(&user.ID, &user.CreatedAt, &user.Version)
+	if err != nil {
+		return err
+	}
+
+	query = `INSERT INTO users_permissions SELECT $1, permissions.id FROM permissions WHERE permissions.code = ANY($2)`
+
+	if _, err := tx.ExecContext(ctx, query, user.ID, pq.Array(codes)); err != nil {
+		return err
+	}
+
+	identity.UserID = user.ID
+
+	query = `INSERT INTO user_identities (user_id, issuer, subject, email) VALUES ($1, $2, $3, $4) RETURNING id, created_at`
+
+	err = tx.QueryRowContext(ctx, query, identity.UserID, identity.Issuer, identity.Subject, identity.Email).Scan(&identity.ID, &identity.CreatedAt)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// SaveAuthRequest remembers the nonce and PKCE verifier of a login that was
+// sent to the provider, keyed by the hash of its state parameter.
+func (m IdentityModel) SaveAuthRequest(state, nonce, codeVerifier string, ttl time.Duration) error {
+
+	stateHash := sha256.Sum256([]byte(state))
+
+	query := `INSERT INTO oidc_auth_requests (state_hash, nonce, code_verifier, expiry) VALUES ($1, $2, $3, $4)`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	if _, err := m.DB.ExecContext(ctx, `DELETE FROM oidc_auth_requests WHERE expiry < NOW()`); err != nil {
+		return err
+	}
+
+	_, err := m.DB.ExecContext(ctx, query, stateHash[:], nonce, codeVerifier, time.Now().Add(ttl))
+
+	return err
+}
+
+// TakeAuthRequest looks up and deletes an unexpired login request, so that
+// each state can complete a login only once.
+func (m IdentityModel) TakeAuthRequest(state string) (nonce, codeVerifier string, err error) {
+
+	stateHash := sha256.Sum256([]byte(state))
+
+	query := `DELETE FROM oidc_auth_requests WHERE state_hash = $1 AND expiry > NOW() RETURNING nonce, code_verifier`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err = m.DB.QueryRowContext(ctx, query, stateHash[:]).Scan(&nonce, &codeVerifier)
+
+	return nonce, codeVerifier, err
+}
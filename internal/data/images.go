@@ -0,0 +1,145 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+const (
+	ImagePoster   = "poster"
+	ImageBackdrop = "backdrop"
+)
+
+// ImageVariant is one stored size of a movie image. URL is filled in from
+// the image store's Key when the image is returned to a client.
+type ImageVariant struct {
+	Key         string `json:"key"`
+	URL         string `json:"url,omitempty"`
+	Width       int    `json:"width"`
+	Height      int    `json:"height"`
+	ContentType string `json:"content_type"`
+}
+
+// MovieImage is a movie's poster or backdrop. A movie has at most one of
+// each, and uploading another replaces it.
+type MovieImage struct {
+	ID        int64                    `json:"id"`
+	MovieID   int64                    `json:"movie_id"`
+	Kind      string                   `json:"kind"`
+	Variants  map[string]*ImageVariant `json:"variants"`
+	CreatedAt time.Time                `json:"created_at"`
+}
+
+type ImageModel struct {
+	DB *sql.DB
+}
+
+// Replace saves a movie image, returning the image of the same kind it
+// replaced, if any, so its files can be deleted. It returns
+// ErrRecordNotFound when the movie doesn't exist.
+func (m ImageModel) Replace(image *MovieImage) (*MovieImage, error) {
+
+	variants, err := json.Marshal(image.Variants)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	query := `DELETE FROM movie_images WHERE movie_id = $1 AND kind = $2
+	RETURNING id, movie_id, kind, variants, created_at`
+
+	replaced, err := scanImage(tx.QueryRowContext(ctx, query, image.MovieID, image.Kind))
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return nil, err
+	}
+
+	query = `INSERT INTO movie_images (movie_id, kind, variants) VALUES ($1, $2, $3) RETURNING id, created_at`
+
+	err = tx.QueryRowContext(ctx, query, image.MovieID, image.Kind, variants).Scan(&image.ID, &image.CreatedAt)
+	if err != nil {
+		var pqErr *pq.Error
+		if errors.As(err, &pqErr) && pqErr.Code.Name() == "foreign_key_violation" {
+			return nil, ErrRecordNotFound
+		}
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	return replaced, nil
+}
+
+// ForMovie returns a movie's images, poster first.
+func (m ImageModel) ForMovie(movieID int64) ([]*MovieImage, error) {
+
+	images, err := m.ForMovies([]int64{movieID})
+	if err != nil {
+		return nil, err
+	}
+	if images[movieID] == nil {
+		return []*MovieImage{}, nil
+	}
+
+	return images[movieID], nil
+}
+
+// ForMovies returns the images of several movies by movie ID, poster first,
+// so a page of movies needs a single query. Movies without images are left
+// out of the map.
+func (m ImageModel) ForMovies(movieIDs []int64) (map[int64][]*MovieImage, error) {
+
+	query := `SELECT id, movie_id, kind, variants, created_at FROM movie_images WHERE movie_id = ANY($1) ORDER BY movie_id, kind DESC`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, pq.Array(movieIDs))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	images := map[int64][]*MovieImage{}
+	for rows.Next() {
+		image, err := scanImage(rows)
+		if err != nil {
+			return nil, err
+		}
+		images[image.MovieID] = append(images[image.MovieID], image)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return images, nil
+}
+
+func scanImage(row interface{ Scan(...interface{}) error }) (*MovieImage, error) {
+	var image MovieImage
+	var variants []byte
+
+	if err := row.Scan(&image.ID, &image.MovieID, &image.Kind, &variants, &image.CreatedAt); err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(variants, &image.Variants); err != nil {
+		return nil, err
+	}
+
+	return &image, nil
+}
@@ -0,0 +1,424 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+var (
+	ErrDuplicateEntry    = errors.New("movie is already in the list")
+	ErrListOrderMismatch = errors.New("order must list every movie in the list exactly once")
+)
+
+// List is a user's named, ordered selection of movies. Private lists, and
+// lists held or hidden by moderation, are only visible to their owner.
+type List struct {
+	ID          int64     `json:"id"`
+	UserID      int64     `json:"user_id"`
+	UserName    string    `json:"user_name,omitempty"`
+	Name        string    `json:"name" validate:"required,max=100"`
+	Description string    `json:"description" validate:"max=2000"`
+	Public      bool      `json:"public"`
+	Status      string    `json:"status"`
+	EntryCount  int       `json:"entry_count"`
+	CreatedAt   time.Time `json:"created_at"`
+	UpdatedAt   time.Time `json:"updated_at"`
+	Version     int32     `json:"version"`
+}
+
+// ListEntry is a movie at a position in a list, with the owner's note.
+type ListEntry struct {
+	Position int       `json:"position"`
+	Note     string    `json:"note" validate:"max=1000"`
+	AddedAt  time.Time `json:"added_at"`
+	Movie    *Movie    `json:"movie"`
+}
+
+type ListModel struct {
+	DB *sql.DB
+}
+
+func (m ListModel) Insert(list *List, reasons []string) error {
+
+	if list.Status == "" {
+		list.Status = StatusPublished
+	}
+
+	query := `INSERT INTO lists (user_id, name, description, public, status) VALUES ($1, $2, $3, $4, $5)
+	RETURNING id, created_at, updated_at, version`
+
+	args := []interface{}{list.UserID, list.Name, list.Description, list.Public, list.Status}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := tx.QueryRowContext(ctx, query, args...).Scan(&list.ID, &list.CreatedAt, &list.UpdatedAt, &list.Version); err != nil {
+		return err
+	}
+
+	if list.Status == StatusHeld {
+		if err := hold(ctx, tx, ContentList, list.ID, reasons); err != nil {
+			return err
+		}
+	}
+
+	if err := recordEvent(ctx, tx, &Event{UserID: list.UserID, Kind: EventList, ListID: &list.ID}); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// listColumns selects a List from lists joined with users.
+const listColumns = `lists.id, lists.user_id, users.name, lists.name, lists.description, lists.public, lists.status,
+(SELECT count(*) FROM list_entries WHERE list_entries.list_id = lists.id), lists.created_at, lists.updated_at, lists.version`
+
+func scanList(row interface{ Scan(...interface{}) error }, list *List, extra ...interface{}) error {
+	return row.Scan(append(extra, &list.ID, &list.UserID, &list.UserName, &list.Name, &list.Description, &list.Public, &list.Status,
+		&list.EntryCount, &list.CreatedAt, &list.UpdatedAt, &list.Version)...)
+}
+
+// Get returns a list if viewerID may see it: the owner always can, anyone
+// else only when it is public and published. Otherwise it returns
+// sql.ErrNoRows, as if the list didn't exist.
+func (m ListModel) Get(listID, viewerID int64) (*List, error) {
+
+	query := `SELECT ` + listColumns + `
+	FROM lists INNER JOIN users ON users.id = lists.user_id
+	WHERE lists.id = $1 AND (lists.user_id = $2 OR (lists.public AND lists.status = 'published'))`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var list List
+	if err := scanList(m.DB.QueryRowContext(ctx, query, listID, viewerID), &list); err != nil {
+		return nil, err
+	}
+
+	return &list, nil
+}
+
+// Update replaces a list's name, description and visibility. Only the owner
+// can update it. Held and hidden lists keep their status until a moderator
+// acts, since the text that got them held may be in an entry note.
+func (m ListModel) Update(list *List, reasons []string) error {
+
+	query := `UPDATE lists SET name = $3, description = $4, public = $5, updated_at = NOW(), version = version + 1,
+	status = CASE WHEN status = 'published' THEN $6 ELSE status END
+	WHERE id = $1 AND user_id = $2
+	RETURNING status, created_at, updated_at, version, (SELECT count(*) FROM list_entries WHERE list_id = $1)`
+
+	args := []interface{}{list.ID, list.UserID, list.Name, list.Description, list.Public, list.Status}
+	screened := list.Status
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	err = tx.QueryRowContext(ctx, query, args...).Scan(&list.Status, &list.CreatedAt, &list.UpdatedAt, &list.Version, &list.EntryCount)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrRecordNotFound
+		}
+		return err
+	}
+
+	if screened == StatusHeld && list.Status == StatusHeld {
+		if err := hold(ctx, tx, ContentList, list.ID, reasons); err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+func (m ListModel) Delete(listID, userID int64) error {
+
+	query := `DELETE FROM lists WHERE id = $1 AND user_id = $2`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, listID, userID)
+	if err != nil {
+		return err
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
+
+// listSortClauses maps the public sort values to ORDER BY clauses.
+var listSortClauses = map[string]string{
+	"updated": "lists.updated_at DESC",
+	"newest":  "lists.created_at DESC",
+	"name":    "lists.name ASC",
+}
+
+// List pages through lists. With ownerID set, it lists that user's lists,
+// including private ones when includePrivate is true; otherwise it lists
+// every public, published list. name filters by words in the list name.
+func (m ListModel) List(ownerID int64, includePrivate bool, name string, filters Filters) ([]*List, Metadata, error) {
+
+	orderBy, ok := listSortClauses[filters.Sort]
+	if !filters.ValidSort() || !ok {
+		panic("unsafe sort parameter: " + filters.Sort)
+	}
+
+	query := fmt.Sprintf(`SELECT count(*) OVER(), `+listColumns+`
+	FROM lists INNER JOIN users ON users.id = lists.user_id
+	WHERE ($1 = 0 OR lists.user_id = $1)
+	AND ($2 OR (lists.public AND lists.status = 'published'))
+	AND (to_tsvector('simple', lists.name) @@ plainto_tsquery('simple', $3) OR $3 = '')
+	ORDER BY %s, lists.id DESC LIMIT $4 OFFSET $5`, orderBy)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, ownerID, includePrivate && ownerID != 0, name, filters.limit(), filters.offset())
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	defer rows.Close()
+
+	lists := []*List{}
+	totalRecords := 0
+
+	for rows.Next() {
+		var list List
+		if err := scanList(rows, &list, &totalRecords); err != nil {
+			return nil, Metadata{}, err
+		}
+		lists = append(lists, &list)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	return lists, calculateMetadata(totalRecords, filters.Page, filters.PageSize), nil
+}
+
+// Entries pages through a list's movies in list order.
+func (m ListModel) Entries(listID int64, filters Filters) ([]*ListEntry, Metadata, error) {
+
+	query := `SELECT count(*) OVER(), list_entries.position, list_entries.note, list_entries.added_at,
+	movies.id, movies.created_at, movies.title, movies.year, movies.runtime, movies.genres, movies.version
+	FROM list_entries
+	INNER JOIN movies ON movies.id = list_entries.movie_id
+	WHERE list_entries.list_id = $1
+	ORDER BY list_entries.position ASC LIMIT $2 OFFSET $3`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, listID, filters.limit(), filters.offset())
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	defer rows.Close()
+
+	entries := []*ListEntry{}
+	totalRecords := 0
+
+	for rows.Next() {
+		var entry ListEntry
+		var movie Movie
+
+		err := rows.Scan(
+			&totalRecords,
+			&entry.Position,
+			&entry.Note,
+			&entry.AddedAt,
+			&movie.ID,
+			&movie.CreatedAt,
+			&movie.Title,
+			&movie.Year,
+			&movie.Runtime,
+			pq.Array(&movie.Genres),
+			&movie.Version,
+		)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+		entry.Movie = &movie
+
+		entries = append(entries, &entry)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	return entries, calculateMetadata(totalRecords, filters.Page, filters.PageSize), nil
+}
+
+// changeEntries runs an entry change in a transaction that first locks the
+// owner's list, so that concurrent changes can't interleave positions, and
+// afterwards bumps the list's updated_at. A published list is held when
+// status is StatusHeld.
+func (m ListModel) changeEntries(listID, userID int64, status string, reasons []string, change func(ctx context.Context, tx *sql.Tx, size int) error) error {
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var locked int64
+	err = tx.QueryRowContext(ctx, `SELECT id FROM lists WHERE id = $1 AND user_id = $2 FOR UPDATE`, listID, userID).Scan(&locked)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrRecordNotFound
+		}
+		return err
+	}
+
+	var size int
+	if err := tx.QueryRowContext(ctx, `SELECT count(*) FROM list_entries WHERE list_id = $1`, listID).Scan(&size); err != nil {
+		return err
+	}
+
+	if err := change(ctx, tx, size); err != nil {
+		return err
+	}
+
+	query := `UPDATE lists SET updated_at = NOW(), status = CASE WHEN status = 'published' AND $2 = 'held' THEN $2 ELSE status END WHERE id = $1
+	RETURNING status`
+	var current string
+	if err := tx.QueryRowContext(ctx, query, listID, status).Scan(&current); err != nil {
+		return err
+	}
+
+	if status == StatusHeld && current == StatusHeld {
+		if err := hold(ctx, tx, ContentList, listID, reasons); err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// AddEntry puts a movie in a list at entry.Position, moving later entries
+// down, or at the end when the position is zero or past the end. It returns
+// ErrDuplicateEntry when the movie is already in the list and
+// ErrRecordNotFound when the list or movie doesn't exist.
+func (m ListModel) AddEntry(listID, userID int64, status string, reasons []string, entry *ListEntry) error {
+	return m.changeEntries(listID, userID, status, reasons, func(ctx context.Context, tx *sql.Tx, size int) error {
+		if entry.Position < 1 || entry.Position > size+1 {
+			entry.Position = size + 1
+		}
+
+		_, err := tx.ExecContext(ctx, `UPDATE list_entries SET position = position + 1 WHERE list_id = $1 AND position >= $2`, listID, entry.Position)
+		if err != nil {
+			return err
+		}
+
+		query := `INSERT INTO list_entries (list_id, movie_id, position, note) VALUES ($1, $2, $3, $4)
+		RETURNING added_at, (SELECT title FROM movies WHERE id = $2)`
+
+		err = tx.QueryRowContext(ctx, query, listID, entry.Movie.ID, entry.Position, entry.Note).Scan(&entry.AddedAt, &entry.Movie.Title)
+		if err != nil {
+			var pqErr *pq.Error
+			if errors.As(err, &pqErr) {
+				switch pqErr.Code.Name() {
+				case "unique_violation":
+					return ErrDuplicateEntry
+				case "foreign_key_violation":
+					return ErrRecordNotFound
+				}
+			}
+			return err
+		}
+
+		return recordEvent(ctx, tx, &Event{UserID: userID, Kind: EventList, ListID: &listID, MovieID: &entry.Movie.ID})
+	})
+}
+
+// UpdateEntryNote replaces the note on a list entry.
+func (m ListModel) UpdateEntryNote(listID, userID int64, status string, reasons []string, entry *ListEntry) error {
+	return m.changeEntries(listID, userID, status, reasons, func(ctx context.Context, tx *sql.Tx, size int) error {
+		query := `UPDATE list_entries SET note = $3 WHERE list_id = $1 AND movie_id = $2
+		RETURNING position, added_at, (SELECT title FROM movies WHERE id = $2)`
+
+		err := tx.QueryRowContext(ctx, query, listID, entry.Movie.ID, entry.Note).Scan(&entry.Position, &entry.AddedAt, &entry.Movie.Title)
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrRecordNotFound
+		}
+		return err
+	})
+}
+
+// RemoveEntry takes a movie out of a list, closing the gap it leaves.
+func (m ListModel) RemoveEntry(listID, userID, movieID int64) error {
+	return m.changeEntries(listID, userID, "", nil, func(ctx context.Context, tx *sql.Tx, size int) error {
+		var position int
+		err := tx.QueryRowContext(ctx, `DELETE FROM list_entries WHERE list_id = $1 AND movie_id = $2 RETURNING position`, listID, movieID).Scan(&position)
+		if err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				return ErrRecordNotFound
+			}
+			return err
+		}
+
+		_, err = tx.ExecContext(ctx, `UPDATE list_entries SET position = position - 1 WHERE list_id = $1 AND position > $2`, listID, position)
+		return err
+	})
+}
+
+// Reorder puts a list's movies in the given order, which must contain every
+// movie in the list exactly once.
+func (m ListModel) Reorder(listID, userID int64, movieIDs []int64) error {
+	return m.changeEntries(listID, userID, "", nil, func(ctx context.Context, tx *sql.Tx, size int) error {
+		if len(movieIDs) != size {
+			return ErrListOrderMismatch
+		}
+
+		query := `UPDATE list_entries SET position = ordered.position
+		FROM unnest($2::bigint[]) WITH ORDINALITY AS ordered(movie_id, position)
+		WHERE list_entries.list_id = $1 AND list_entries.movie_id = ordered.movie_id`
+
+		result, err := tx.ExecContext(ctx, query, listID, pq.Array(movieIDs))
+		if err != nil {
+			var pqErr *pq.Error
+			if errors.As(err, &pqErr) && pqErr.Code.Name() == "unique_violation" {
+				return ErrListOrderMismatch
+			}
+			return err
+		}
+		rowsAffected, err := result.RowsAffected()
+		if err != nil {
+			return err
+		}
+		if int(rowsAffected) != size {
+			return ErrListOrderMismatch
+		}
+
+		return nil
+	})
+}
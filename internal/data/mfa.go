@@ -0,0 +1,241 @@
+package data
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base32"
+	"errors"
+	"time"
+)
+
+// MFA is a user's TOTP enrolment. Secret is encrypted by the caller before
+// it reaches this model, so the database never holds a usable seed.
+type MFA struct {
+	UserID         int64
+	Secret         []byte
+	Enabled        bool
+	LastUsedStep   int64
+	FailedAttempts int
+}
+
+type MFAModel struct {
+	DB *sql.DB
+}
+
+func (m MFAModel) Get(userID int64) (*MFA, error) {
+
+	query := `SELECT user_id, secret, enabled, last_used_step, failed_attempts FROM user_mfa WHERE user_id = $1`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var mfa MFA
+	err := m.DB.QueryRowContext(ctx, query, userID).Scan(&mfa.UserID, &mfa.Secret, &mfa.Enabled, &mfa.LastUsedStep, &mfa.FailedAttempts)
+	if err != nil {
+		return nil, err
+	}
+
+	return &mfa, nil
+}
+
+// IsEnabled reports whether a user has confirmed a TOTP enrolment.
+func (m MFAModel) IsEnabled(userID int64) (bool, error) {
+
+	query := `SELECT EXISTS(SELECT 1 FROM user_mfa WHERE user_id = $1 AND enabled)`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var enabled bool
+	err := m.DB.QueryRowContext(ctx, query, userID).Scan(&enabled)
+
+	return enabled, err
+}
+
+// Begin stores a new, not yet confirmed secret, replacing any earlier
+// unconfirmed one. It returns ErrRecordNotFound if 2FA is already enabled.
+func (m MFAModel) Begin(userID int64, secret []byte) error {
+
+	query := `INSERT INTO user_mfa (user_id, secret) VALUES ($1, $2)
+	ON CONFLICT (user_id) DO UPDATE SET secret = EXCLUDED.secret, last_used_step = 0, failed_attempts = 0, created_at = NOW()
+	WHERE user_mfa.enabled = false`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, userID, secret)
+	if err != nil {
+		return err
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
+
+// Enable confirms an enrolment and replaces the user's recovery codes with
+// a fresh set, returned in plaintext. Only their hashes are stored.
+func (m MFAModel) Enable(userID, step int64) ([]string, error) {
+
+	codes := make([]string, 10)
+	for i := range codes {
+		randomBytes := make([]byte, 5)
+		if _, err := rand.Read(randomBytes); err != nil {
+			return nil, err
+		}
+		code := base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(randomBytes)
+		codes[i] = code[:4] + "-" + code[4:]
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	query := `UPDATE user_mfa SET enabled = true, confirmed_at = NOW(), last_used_step = $2, failed_attempts = 0
+	WHERE user_id = $1 AND enabled = false`
+
+	result, err := tx.ExecContext(ctx, query, userID, step)
+	if err != nil {
+		return nil, err
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return nil, err
+	}
+	if rowsAffected == 0 {
+		return nil, ErrRecordNotFound
+	}
+
+	if _, err := tx.ExecContext(ctx, `DELETE FROM mfa_recovery_codes WHERE user_id = $1`, userID); err != nil {
+		return nil, err
+	}
+	for _, code := range codes {
+		hash := sha256.Sum256([]byte(code))
+		if _, err := tx.ExecContext(ctx, `INSERT INTO mfa_recovery_codes (user_id, hash) VALUES ($1, $2)`, userID, hash[:]); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	return codes, nil
+}
+
+// UseStep records a successful code. It returns false if the step is not
+// newer than the last accepted one, i.e. the code is being replayed.
+func (m MFAModel) UseStep(userID, step int64) (bool, error) {
+
+	query := `UPDATE user_mfa SET last_used_step = $2, failed_attempts = 0 WHERE user_id = $1 AND last_used_step < $2`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, userID, step)
+	if err != nil {
+		return false, err
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+
+	return rowsAffected == 1, nil
+}
+
+// UseRecoveryCode spends one of the user's recovery codes.
+func (m MFAModel) UseRecoveryCode(userID int64, code string) (bool, error) {
+
+	hash := sha256.Sum256([]byte(code))
+
+	query := `UPDATE mfa_recovery_codes SET used_at = NOW() WHERE user_id = $1 AND hash = $2 AND used_at IS NULL`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, userID, hash[:])
+	if err != nil {
+		return false, err
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	if rowsAffected == 0 {
+		return false, nil
+	}
+
+	_, err = m.DB.ExecContext(ctx, `UPDATE user_mfa SET failed_attempts = 0 WHERE user_id = $1`, userID)
+
+	return true, err
+}
+
+// ClaimAttempt counts a code attempt before the code is checked, so that
+// concurrent requests can't get more than max attempts between them. It
+// returns false without counting once max attempts have failed since the
+// last success. A correct code resets the count.
+func (m MFAModel) ClaimAttempt(userID int64, max int) (attempts int, ok bool, err error) {
+
+	query := `UPDATE user_mfa SET failed_attempts = failed_attempts + 1 WHERE user_id = $1 AND failed_attempts < $2 RETURNING failed_attempts`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err = m.DB.QueryRowContext(ctx, query, userID, max).Scan(&attempts)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, false, nil
+	}
+	if err != nil {
+		return 0, false, err
+	}
+
+	return attempts, true, nil
+}
+
+// ResetFailures gives a user a fresh set of code attempts, when they sign in
+// again with their password.
+func (m MFAModel) ResetFailures(userID int64) error {
+
+	query := `UPDATE user_mfa SET failed_attempts = 0 WHERE user_id = $1`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, userID)
+
+	return err
+}
+
+func (m MFAModel) Delete(userID int64) error {
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, `DELETE FROM mfa_recovery_codes WHERE user_id = $1`, userID); err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, `DELETE FROM user_mfa WHERE user_id = $1`, userID); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
@@ -10,19 +10,49 @@ var (
 )
 
 type Models struct {
-	Movies      MovieModel
-	Users       UserModel
-	Tokens      TokenModel
-	Permissions PermissionModel
-	Ratings     RatingModel
+	Movies          MovieModel
+	Users           UserModel
+	Tokens          TokenModel
+	Permissions     PermissionModel
+	Ratings         RatingModel
+	People          PersonModel
+	Credits         CreditModel
+	MFA             MFAModel
+	Throttles       LoginThrottleModel
+	Identities      IdentityModel
+	Reviews         ReviewModel
+	Moderation      ModerationModel
+	Watchlist       WatchlistModel
+	Diary           DiaryModel
+	Lists           ListModel
+	Follows         FollowModel
+	Activity        ActivityModel
+	Recommendations RecommendationModel
+	Trending        TrendingModel
+	Images          ImageModel
 }
 
 func NewModels(db *sql.DB) Models {
 	return Models{
-		Movies:      MovieModel{DB: db},
-		Users:       UserModel{DB: db},
-		Tokens:      TokenModel{DB: db},
-		Permissions: PermissionModel{DB: db},
-		Ratings:     RatingModel{DB: db},
+		Movies:          MovieModel{DB: db, Weighting: DefaultRatingWeighting},
+		Users:           UserModel{DB: db},
+		Tokens:          TokenModel{DB: db},
+		Permissions:     PermissionModel{DB: db},
+		Ratings:         RatingModel{DB: db},
+		People:          PersonModel{DB: db},
+		Credits:         CreditModel{DB: db},
+		MFA:             MFAModel{DB: db},
+		Throttles:       LoginThrottleModel{DB: db},
+		Identities:      IdentityModel{DB: db},
+		Reviews:         ReviewModel{DB: db},
+		Moderation:      ModerationModel{DB: db},
+		Watchlist:       WatchlistModel{DB: db, Weighting: DefaultRatingWeighting},
+		Diary:           DiaryModel{DB: db},
+		Lists:           ListModel{DB: db},
+		Follows:         FollowModel{DB: db},
+		Activity:        ActivityModel{DB: db},
+		Recommendations: RecommendationModel{DB: db, MinOverlap: DefaultSimilarityMinOverlap, Neighbours: DefaultSimilarityNeighbours},
+		Trending:        TrendingModel{DB: db},
+		Images:          ImageModel{DB: db},
 	}
 }
@@ -0,0 +1,342 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// Content types that can be flagged and moderated.
+const (
+	ContentReview = "review"
+	ContentList   = "list"
+)
+
+// Statuses of moderated content. Only published content is shown publicly.
+const (
+	StatusPublished = "published"
+	StatusHeld      = "held"
+	StatusHidden    = "hidden"
+)
+
+// Moderation actions. Hold is recorded when the banned-word filter holds a
+// submission; the others are taken by moderators.
+const (
+	ActionHold    = "hold"
+	ActionHide    = "hide"
+	ActionRestore = "restore"
+	ActionDelete  = "delete"
+)
+
+// moderatedContent describes where each content type is stored. The table
+// must have id and status columns; text and author are SQL expressions over
+// it giving what the moderator reads and who wrote it.
+var moderatedContent = map[string]struct {
+	table  string
+	text   string
+	author string
+}{
+	ContentReview: {table: "reviews", text: "concat_ws(E'\\n\\n', NULLIF(title, ''), body)", author: "user_id"},
+	ContentList: {table: "lists", author: "user_id",
+		text: "concat_ws(E'\\n\\n', name, NULLIF(description, ''), (SELECT string_agg(note, E'\\n' ORDER BY position) FROM list_entries WHERE list_id = lists.id AND note <> ''))"},
+}
+
+// ContentTypes lists the content types that can be moderated.
+func ContentTypes() []string {
+	types := make([]string, 0, len(moderatedContent))
+	for t := range moderatedContent {
+		types = append(types, t)
+	}
+	sort.Strings(types)
+	return types
+}
+
+// QueueItem is content waiting for a moderator, either because users flagged
+// it or because the banned-word filter held it.
+type QueueItem struct {
+	ContentType string    `json:"content_type"`
+	ContentID   int64     `json:"content_id"`
+	AuthorID    int64     `json:"author_id"`
+	Content     string    `json:"content"`
+	Status      string    `json:"status"`
+	Reason      string    `json:"reason"`
+	Flags       int       `json:"flags"`
+	FlagReasons []string  `json:"flag_reasons"`
+	CreatedAt   time.Time `json:"created_at"`
+	UpdatedAt   time.Time `json:"updated_at"`
+}
+
+// ModerationAction is an entry of the moderation log. ModeratorID is nil for
+// actions taken automatically.
+type ModerationAction struct {
+	ID          int64     `json:"id"`
+	ContentType string    `json:"content_type" validate:"required"`
+	ContentID   int64     `json:"content_id" validate:"required,min=1"`
+	ModeratorID *int64    `json:"moderator_id"`
+	Action      string    `json:"action" validate:"required,oneof=hide restore delete"`
+	Note        string    `json:"note" validate:"max=1000"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+type ModerationModel struct {
+	DB *sql.DB
+}
+
+// Flag records a user's report of published content and puts the content in
+// the moderation queue. Flagging the same content again replaces the reason.
+func (m ModerationModel) Flag(contentType string, contentID, userID int64, reason string) error {
+	source, ok := moderatedContent[contentType]
+	if !ok {
+		return ErrRecordNotFound
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var exists bool
+	query := fmt.Sprintf(`SELECT EXISTS (SELECT 1 FROM %s WHERE id = $1 AND status = $2)`, source.table)
+	if err := tx.QueryRowContext(ctx, query, contentID, StatusPublished).Scan(&exists); err != nil {
+		return err
+	}
+	if !exists {
+		return ErrRecordNotFound
+	}
+
+	query = `INSERT INTO content_flags (content_type, content_id, user_id, reason) VALUES ($1, $2, $3, $4)
+	ON CONFLICT (content_type, content_id, user_id) DO UPDATE
+	SET reason = EXCLUDED.reason, created_at = NOW(), resolved_at = NULL`
+
+	if _, err := tx.ExecContext(ctx, query, contentType, contentID, userID, reason); err != nil {
+		return err
+	}
+
+	if err := enqueue(ctx, tx, contentType, contentID, "flagged"); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// hold puts content that the banned-word filter stopped in the moderation
+// queue and logs the hold. It runs in the transaction that saves the content
+// with StatusHeld, so held content can't end up missing from the queue.
+func hold(ctx context.Context, tx *sql.Tx, contentType string, contentID int64, reasons []string) error {
+	note := strings.Join(reasons, "; ")
+
+	if err := enqueue(ctx, tx, contentType, contentID, note); err != nil {
+		return err
+	}
+
+	action := &ModerationAction{ContentType: contentType, ContentID: contentID, Action: ActionHold, Note: note}
+	return insertAction(ctx, tx, action)
+}
+
+func enqueue(ctx context.Context, tx *sql.Tx, contentType string, contentID int64, reason string) error {
+	query := `INSERT INTO moderation_queue (content_type, content_id, reason) VALUES ($1, $2, $3)
+	ON CONFLICT (content_type, content_id) DO UPDATE SET updated_at = NOW()`
+
+	_, err := tx.ExecContext(ctx, query, contentType, contentID, reason)
+	return err
+}
+
+func insertAction(ctx context.Context, tx *sql.Tx, action *ModerationAction) error {
+	query := `INSERT INTO moderation_actions (content_type, content_id, moderator_id, action, note)
+	VALUES ($1, $2, $3, $4, $5) RETURNING id, created_at`
+
+	return tx.QueryRowContext(ctx, query, action.ContentType, action.ContentID, action.ModeratorID, action.Action, action.Note).Scan(&action.ID, &action.CreatedAt)
+}
+
+// Moderate applies a moderator's hide, restore or delete action to content,
+// takes it off the queue, resolves its open flags and logs the action.
+func (m ModerationModel) Moderate(action *ModerationAction) error {
+	source, ok := moderatedContent[action.ContentType]
+	if !ok {
+		return ErrRecordNotFound
+	}
+
+	var query string
+	args := []interface{}{action.ContentID}
+	switch action.Action {
+	case ActionHide:
+		query = fmt.Sprintf(`UPDATE %s SET status = $2 WHERE id = $1`, source.table)
+		args = append(args, StatusHidden)
+	case ActionRestore:
+		query = fmt.Sprintf(`UPDATE %s SET status = $2 WHERE id = $1`, source.table)
+		args = append(args, StatusPublished)
+	case ActionDelete:
+		query = fmt.Sprintf(`DELETE FROM %s WHERE id = $1`, source.table)
+	default:
+		return fmt.Errorf("unknown moderation action %q", action.Action)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.ExecContext(ctx, query, args...)
+	if err != nil {
+		return err
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	_, err = tx.ExecContext(ctx, `DELETE FROM moderation_queue WHERE content_type = $1 AND content_id = $2`, action.ContentType, action.ContentID)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, `UPDATE content_flags SET resolved_at = NOW() WHERE content_type = $1 AND content_id = $2 AND resolved_at IS NULL`,
+		action.ContentType, action.ContentID)
+	if err != nil {
+		return err
+	}
+
+	if err := insertAction(ctx, tx, action); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// queueSortClauses maps the public sort values to ORDER BY clauses.
+var queueSortClauses = map[string]string{
+	"oldest": "moderation_queue.created_at ASC",
+	"newest": "moderation_queue.created_at DESC",
+	"flags":  "flags DESC, moderation_queue.created_at ASC",
+}
+
+// Queue pages through content waiting for a moderator.
+func (m ModerationModel) Queue(filters Filters) ([]*QueueItem, Metadata, error) {
+
+	orderBy, ok := queueSortClauses[filters.Sort]
+	if !filters.ValidSort() || !ok {
+		panic("unsafe sort parameter: " + filters.Sort)
+	}
+
+	var sources []string
+	for _, contentType := range ContentTypes() {
+		source := moderatedContent[contentType]
+		sources = append(sources, fmt.Sprintf(`SELECT %s AS content_type, id, %s AS author_id, %s AS content, status FROM %s`,
+			pq.QuoteLiteral(contentType), source.author, source.text, source.table))
+	}
+
+	query := fmt.Sprintf(`SELECT count(*) OVER(), moderation_queue.content_type, moderation_queue.content_id,
+	content.author_id, content.content, content.status, moderation_queue.reason,
+	count(content_flags.id) AS flags,
+	COALESCE(array_agg(content_flags.reason ORDER BY content_flags.created_at) FILTER (WHERE content_flags.id IS NOT NULL), '{}'),
+	moderation_queue.created_at, moderation_queue.updated_at
+	FROM moderation_queue
+	INNER JOIN (%s) AS content ON content.content_type = moderation_queue.content_type AND content.id = moderation_queue.content_id
+	LEFT JOIN content_flags ON content_flags.content_type = moderation_queue.content_type
+	AND content_flags.content_id = moderation_queue.content_id AND content_flags.resolved_at IS NULL
+	GROUP BY moderation_queue.content_type, moderation_queue.content_id, content.author_id, content.content, content.status
+	ORDER BY %s, moderation_queue.content_type, moderation_queue.content_id LIMIT $1 OFFSET $2`,
+		strings.Join(sources, " UNION ALL "), orderBy)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, filters.limit(), filters.offset())
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	defer rows.Close()
+
+	items := []*QueueItem{}
+	totalRecords := 0
+
+	for rows.Next() {
+		var item QueueItem
+		var authorID sql.NullInt64
+
+		err := rows.Scan(
+			&totalRecords,
+			&item.ContentType,
+			&item.ContentID,
+			&authorID,
+			&item.Content,
+			&item.Status,
+			&item.Reason,
+			&item.Flags,
+			pq.Array(&item.FlagReasons),
+			&item.CreatedAt,
+			&item.UpdatedAt,
+		)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+		item.AuthorID = authorID.Int64
+
+		items = append(items, &item)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	return items, calculateMetadata(totalRecords, filters.Page, filters.PageSize), nil
+}
+
+// Actions pages through the moderation log, newest first, optionally for a
+// single piece of content.
+func (m ModerationModel) Actions(contentType string, contentID int64, filters Filters) ([]*ModerationAction, Metadata, error) {
+
+	query := `SELECT count(*) OVER(), id, content_type, content_id, moderator_id, action, note, created_at
+	FROM moderation_actions
+	WHERE ($1 = '' OR content_type = $1) AND ($2 = 0 OR content_id = $2)
+	ORDER BY created_at DESC, id DESC LIMIT $3 OFFSET $4`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, contentType, contentID, filters.limit(), filters.offset())
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	defer rows.Close()
+
+	actions := []*ModerationAction{}
+	totalRecords := 0
+
+	for rows.Next() {
+		var action ModerationAction
+		var moderatorID sql.NullInt64
+
+		err := rows.Scan(&totalRecords, &action.ID, &action.ContentType, &action.ContentID, &moderatorID, &action.Action, &action.Note, &action.CreatedAt)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+		if moderatorID.Valid {
+			action.ModeratorID = &moderatorID.Int64
+		}
+
+		actions = append(actions, &action)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	return actions, calculateMetadata(totalRecords, filters.Page, filters.PageSize), nil
+}
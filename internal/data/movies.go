@@ -5,7 +5,6 @@ import (
 	"database/sql"
 	"fmt"
 	"log"
-	"strings"
 	"time"
 
 	"github.com/go-playground/validator/v10"
@@ -13,7 +12,8 @@ import (
 )
 
 type MovieModel struct {
-	DB *sql.DB
+	DB        *sql.DB
+	Weighting RatingWeighting
 }
 
 type Movie struct {
@@ -24,6 +24,22 @@ type Movie struct {
 	Runtime   int32     `json:"runtime,omitempty" validate:"required,min=20"`
 	Genres    []string  `json:"genres,omitempty" validate:"required,min=1,max=10,unique"`
 	Version   int32     `json:"version" validate:"omitempty,min=1"`
+
+	AverageRating  float64 `json:"average_rating,omitempty"`
+	RatingCount    int64   `json:"rating_count,omitempty"`
+	WeightedRating float64 `json:"weighted_rating,omitempty"`
+
+	// InWatchlist is only set for signed in callers.
+	InWatchlist *bool `json:"in_watchlist,omitempty"`
+
+	// Images is loaded by the handlers, a page of movies at a time.
+	Images []*MovieImage `json:"images,omitempty"`
+}
+
+// movieSortColumns maps the public sort values that aren't plain movie
+// columns to the columns of the List query.
+var movieSortColumns = map[string]string{
+	"rating": "weighted_rating",
 }
 
 func maxCurrentYear(fl validator.FieldLevel) bool {
@@ -45,11 +61,13 @@ func (m MovieModel) Get(id int64) (*Movie, error) {
 	if id < 1 {
 		return nil, ErrRecordNotFound
 	}
-	query := "SELECT * FROM movies WHERE id=$1"
+	query := fmt.Sprintf(`SELECT id, created_at, title, year, runtime, genres, version, %s, rating_count, %s
+	FROM movies WHERE id=$1`, movieAverageRating, m.Weighting.expression())
 
 	var movie Movie
 
-	if err := m.DB.QueryRow(query, id).Scan(&movie.ID, &movie.CreatedAt, &movie.Title, &movie.Year, &movie.Runtime, pq.Array(&movie.Genres), &movie.Version); err != nil {
+	if err := m.DB.QueryRow(query, id).Scan(&movie.ID, &movie.CreatedAt, &movie.Title, &movie.Year, &movie.Runtime, pq.Array(&movie.Genres), &movie.Version,
+		&movie.AverageRating, &movie.RatingCount, &movie.WeightedRating); err != nil {
 		return nil, err
 	}
 
@@ -95,22 +113,30 @@ func (m MovieModel) Delete(id int64) error {
 	return nil
 }
 
-func (m MovieModel) List(title string, genres []string, filters Filters) ([]*Movie, Metadata, error) {
+func (m MovieModel) List(title string, genres []string, personID int64, filters Filters) ([]*Movie, Metadata, error) {
 
 	// 	query := `SELECT id, created_at, title, year, runtime, genres, version
 	// FROM movies WHERE (Lower(title)=Lower($1) OR $1='') AND (genres @>$2 OR $2='{}')
 	// ORDER BY id`
 
-	query := fmt.Sprintf(`SELECT count(*) OVER(), id, created_at, title, year, runtime, genres, version
-FROM movies WHERE (to_tsvector('simple',title) @@ plainto_tsquery('simple',$1) OR $1='') AND (genres @>$2 OR $2='{}')
-ORDER BY %s %s,id ASC LIMIT $3 OFFSET $4`, strings.TrimPrefix(filters.Sort, "-"), filters.sortDirection())
+	sortColumn := "movies." + filters.sortColumn()
+	if column, ok := movieSortColumns[filters.sortColumn()]; ok {
+		sortColumn = column
+	}
+
+	query := fmt.Sprintf(`SELECT count(*) OVER(), movies.id, movies.created_at, movies.title, movies.year, movies.runtime, movies.genres, movies.version,
+%s AS average_rating, movies.rating_count, %s AS weighted_rating
+FROM movies
+WHERE (to_tsvector('simple',movies.title) @@ plainto_tsquery('simple',$1) OR $1='') AND (movies.genres @>$2 OR $2='{}')
+AND ($3 = 0 OR movies.id IN (SELECT movie_id FROM credits WHERE person_id = $3))
+ORDER BY %s %s,movies.id ASC LIMIT $4 OFFSET $5`, movieAverageRating, m.Weighting.expression(), sortColumn, filters.sortDirection())
 
 	log.Print(query)
 
 	// ctx, cancel := con.WithTimeout(context.Background(), 3*time.Second)
 	// defer cancel()
 
-	rows, err := m.DB.Query(query, title, pq.Array(genres), filters.limit(), filters.offset())
+	rows, err := m.DB.Query(query, title, pq.Array(genres), personID, filters.limit(), filters.offset())
 	if err != nil {
 		log.Print(err)
 		return nil, Metadata{}, err
@@ -135,6 +161,9 @@ ORDER BY %s %s,id ASC LIMIT $3 OFFSET $4`, strings.TrimPrefix(filters.Sort, "-")
 			&movie.Runtime,
 			pq.Array(&movie.Genres),
 			&movie.Version,
+			&movie.AverageRating,
+			&movie.RatingCount,
+			&movie.WeightedRating,
 		)
 
 		if err != nil {
@@ -154,21 +183,15 @@ ORDER BY %s %s,id ASC LIMIT $3 OFFSET $4`, strings.TrimPrefix(filters.Sort, "-")
 
 }
 
+// GetAverageRating reads a movie's rating aggregates. It returns
+// sql.ErrNoRows when the movie doesn't exist.
 func (m *MovieModel) GetAverageRating(movie_ID int64) (*AverageRating, error) {
 
-	//query := `SELECT AVG(rating) AS average_rating,count(*) AS rating_count FROM ratings WHERE movie_id = $1`
-	query := `SELECT COALESCE(AVG(rating), 0) AS average_rating,COALESCE(count(*), 0) AS rating_count FROM ratings
-	WHERE movie_id = $1;`
+	query := fmt.Sprintf(`SELECT %s, rating_count FROM movies WHERE id = $1`, movieAverageRating)
 
 	var averageRating AverageRating
 
 	if err := m.DB.QueryRow(query, movie_ID).Scan(&averageRating.AverageRating, &averageRating.RatingCount); err != nil {
-		if err == sql.ErrNoRows {
-			// No ratings found for the movie, set averageRating to 0
-			averageRating.AverageRating = 0
-			averageRating.RatingCount = 0
-			return &averageRating, nil
-		}
 		return nil, err
 	}
 
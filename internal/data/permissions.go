@@ -55,7 +55,8 @@ func (m *PermissionModel) GetAllForUser(userID int64) (Permissions, error) {
 func (m *PermissionModel) AddForUser(userID int64, codes ...string) error {
 
 	log.Print("add Permission")
-	query := `INSERT INTO users_permissions SELECT $1, permissions.id FROM permissions WHERE permissions.code = ANY($2)`
+	query := `INSERT INTO users_permissions SELECT $1, permissions.id FROM permissions WHERE permissions.code = ANY($2)
+	ON CONFLICT DO NOTHING`
 
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
@@ -66,3 +67,77 @@ func (m *PermissionModel) AddForUser(userID int64, codes ...string) error {
 
 	return err
 }
+
+func (m *PermissionModel) RemoveForUser(userID int64, codes ...string) error {
+
+	query := `DELETE FROM users_permissions USING permissions
+	WHERE users_permissions.permission_id = permissions.id AND users_permissions.user_id = $1 AND permissions.code = ANY($2)`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, userID, pq.Array(codes))
+
+	return err
+}
+
+// ListAll returns every permission code that can be granted to a user.
+func (m *PermissionModel) ListAll() (Permissions, error) {
+
+	query := `SELECT code FROM permissions ORDER BY code`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	permissions := Permissions{}
+	for rows.Next() {
+		var permission string
+		if err := rows.Scan(&permission); err != nil {
+			return nil, err
+		}
+		permissions = append(permissions, permission)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return permissions, nil
+}
+
+// GetAllForToken returns the permissions an API key may use: the codes it
+// was issued with, minus any its owner has since lost.
+func (m *PermissionModel) GetAllForToken(tokenID int64) (Permissions, error) {
+
+	query := `SELECT permissions.code FROM permissions
+	INNER JOIN tokens_permissions ON tokens_permissions.permission_id = permissions.id
+	INNER JOIN tokens ON tokens.id = tokens_permissions.token_id
+	INNER JOIN users_permissions ON users_permissions.permission_id = permissions.id AND users_permissions.user_id = tokens.user_id
+	WHERE tokens.id = $1`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	rows, err := m.DB.QueryContext(ctx, query, tokenID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var permissions Permissions
+	for rows.Next() {
+		var permission string
+		err := rows.Scan(&permission)
+		if err != nil {
+			return nil, err
+		}
+		permissions = append(permissions, permission)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return permissions, nil
+}
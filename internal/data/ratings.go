@@ -3,27 +3,36 @@ package data
 import (
 	"context"
 	"database/sql"
+	"errors"
 	"time"
 )
 
 type Rating struct {
-	User_id    int64     `json:"user_id"`
-	Movie_id   int64     `json:"movie_id"`
-	Rating     float64   `json:"rating"`
-	Created_at time.Time `json:"created_at"`
-	Version    int32     `json:"version"`
+	User_id    int64        `json:"user_id"`
+	Movie_id   int64        `json:"movie_id"`
+	Rating     float64      `json:"rating"`
+	Created_at time.Time    `json:"created_at"`
+	Version    int32        `json:"version"`
+	Review     *Review      `json:"review,omitempty"`
+	Scale      *RatingScale `json:"scale,omitempty"`
 }
 
 type AverageRating struct {
-	AverageRating float64 `json:"average_rating"`
-	RatingCount   int64   `json:"rating_count"`
+	AverageRating float64      `json:"average_rating"`
+	RatingCount   int64        `json:"rating_count"`
+	Scale         *RatingScale `json:"scale,omitempty"`
 }
 
 type RatingModel struct {
 	DB *sql.DB
 }
 
-func (m *RatingModel) AddRating(rating *Rating) error {
+// AddRating inserts a rating and, in the same transaction, adds it to the
+// movie's rating aggregates and records it for the activity feed. When
+// rating.Review is set, the review is written in the same transaction too, so
+// a failed review doesn't leave the rating behind; reasons are the banned-word
+// filter's reasons for holding it.
+func (m *RatingModel) AddRating(rating *Rating, reasons []string) error {
 
 	query := `INSERT INTO ratings (user_id, movie_id, rating) VALUES ($1,$2,$3) RETURNING user_id,movie_id,rating,created_at,version`
 
@@ -32,14 +41,46 @@ func (m *RatingModel) AddRating(rating *Rating) error {
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 
-	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&rating.User_id, &rating.Movie_id, &rating.Rating, &rating.Created_at, &rating.Version)
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	err = tx.QueryRowContext(ctx, query, args...).Scan(&rating.User_id, &rating.Movie_id, &rating.Rating, &rating.Created_at, &rating.Version)
+	if err != nil {
+		return err
+	}
+
+	if err := adjustRatingAggregates(ctx, tx, rating.Movie_id, rating.Rating, 1); err != nil {
+		return err
+	}
+
+	if err := recordEvent(ctx, tx, &Event{UserID: rating.User_id, Kind: EventRating, MovieID: &rating.Movie_id, Rating: &rating.Rating}); err != nil {
+		return err
+	}
 
+	if rating.Review != nil {
+		if err := upsertReview(ctx, tx, rating.Review, reasons); err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// adjustRatingAggregates adds delta to a movie's rating_sum and count to its
+// rating_count.
+func adjustRatingAggregates(ctx context.Context, tx *sql.Tx, movieID int64, delta float64, count int) error {
+	query := `UPDATE movies SET rating_sum = rating_sum + $2, rating_count = rating_count + $3 WHERE id = $1`
+
+	_, err := tx.ExecContext(ctx, query, movieID, delta, count)
 	return err
 }
 
 func (m *RatingModel) GetRating(rating *Rating) error {
 
-	query := `SELECT * from ratings WHERE user_id = $1 AND movie_id = $2`
+	query := `SELECT user_id, movie_id, rating, created_at, version from ratings WHERE user_id = $1 AND movie_id = $2`
 
 	args := []interface{}{rating.User_id, rating.Movie_id}
 
@@ -59,6 +100,21 @@ func (m *RatingModel) GetRating(rating *Rating) error {
 
 func (m *RatingModel) UpdateRating(rating *Rating) error {
 
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var previous float64
+	err = tx.QueryRowContext(ctx, `SELECT rating FROM ratings WHERE user_id = $1 AND movie_id = $2 FOR UPDATE`, rating.User_id, rating.Movie_id).Scan(&previous)
+	if err != nil {
+		return err
+	}
+
 	query := `UPDATE ratings
 	SET rating = $1, version = version + 1
 	WHERE user_id = $2 AND movie_id = $3
@@ -66,35 +122,71 @@ func (m *RatingModel) UpdateRating(rating *Rating) error {
 
 	args := []interface{}{rating.Rating, rating.User_id, rating.Movie_id}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-	defer cancel()
+	err = tx.QueryRowContext(ctx, query, args...).Scan(&rating.User_id, &rating.Movie_id, &rating.Rating, &rating.Created_at, &rating.Version)
+	if err != nil {
+		return err
+	}
 
-	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&rating.User_id, &rating.Movie_id, &rating.Rating, &rating.Created_at, &rating.Version)
+	if err := adjustRatingAggregates(ctx, tx, rating.Movie_id, rating.Rating-previous, 0); err != nil {
+		return err
+	}
 
-	return err
+	if err := recordEvent(ctx, tx, &Event{UserID: rating.User_id, Kind: EventRating, MovieID: &rating.Movie_id, Rating: &rating.Rating}); err != nil {
+		return err
+	}
+
+	return tx.Commit()
 }
 
 func (m *RatingModel) DeleteRating(rating *Rating) error {
 
-	query := `DELETE from ratings where user_id=$1 AND movie_id=$2`
+	query := `DELETE from ratings where user_id=$1 AND movie_id=$2 RETURNING rating`
 
 	args := []interface{}{rating.User_id, rating.Movie_id}
 
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 
-	result, err := m.DB.ExecContext(ctx, query, args...)
-
+	tx, err := m.DB.BeginTx(ctx, nil)
 	if err != nil {
 		return err
 	}
-	rowsAffected, err := result.RowsAffected()
-	if err != nil {
+	defer tx.Rollback()
+
+	var deleted float64
+	if err := tx.QueryRowContext(ctx, query, args...).Scan(&deleted); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrRecordNotFound
+		}
 		return err
 	}
-	if rowsAffected == 0 {
-		return ErrRecordNotFound
+
+	if err := adjustRatingAggregates(ctx, tx, rating.Movie_id, -deleted, -1); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// RepairAggregates recomputes every movie's rating_sum and rating_count from
+// the ratings table, fixing any drift, for example after ratings were edited
+// by hand or removed by a cascading delete. It returns the number of movies
+// that needed fixing.
+func (m *RatingModel) RepairAggregates() (int64, error) {
+
+	query := `UPDATE movies SET rating_sum = aggregates.rating_sum, rating_count = aggregates.rating_count
+	FROM (SELECT movies.id, COALESCE(sum(ratings.rating), 0) AS rating_sum, count(ratings.rating) AS rating_count
+		FROM movies LEFT JOIN ratings ON ratings.movie_id = movies.id GROUP BY movies.id) AS aggregates
+	WHERE movies.id = aggregates.id
+	AND (movies.rating_sum, movies.rating_count) IS DISTINCT FROM (aggregates.rating_sum, aggregates.rating_count)`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query)
+	if err != nil {
+		return 0, err
 	}
 
-	return nil
+	return result.RowsAffected()
 }
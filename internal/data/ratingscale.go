@@ -0,0 +1,68 @@
+package data
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+)
+
+// RatingScale is the set of ratings users can give: Min to Max in steps of
+// Step.
+type RatingScale struct {
+	Min  float64 `json:"min"`
+	Max  float64 `json:"max"`
+	Step float64 `json:"step"`
+}
+
+var DefaultRatingScale = RatingScale{Min: 1, Max: 10, Step: 0.5}
+
+// storedRatingScale is what the CHECK constraints on ratings.rating and
+// diary_entries.rating accept. Configured scales must fit inside it; a wider
+// or finer scale needs a migration replacing those constraints first.
+var storedRatingScale = RatingScale{Min: 1, Max: 10, Step: 0.5}
+
+// Check reports whether the scale makes sense and every rating on it can be
+// stored.
+func (s RatingScale) Check() error {
+	if s.Step <= 0 {
+		return errors.New("rating step must be positive")
+	}
+	if s.Min >= s.Max {
+		return errors.New("rating minimum must be below the maximum")
+	}
+	if !isWhole((s.Max - s.Min) / s.Step) {
+		return errors.New("rating range must be a whole number of steps")
+	}
+	if !storedRatingScale.Valid(s.Min) || s.Max > storedRatingScale.Max || !isWhole(s.Step/storedRatingScale.Step) {
+		return fmt.Errorf("rating scale must fit the stored scale, %s", storedRatingScale)
+	}
+	return nil
+}
+
+// Valid reports whether rating is on the scale.
+func (s RatingScale) Valid(rating float64) bool {
+	return rating >= s.Min && rating <= s.Max && isWhole((rating-s.Min)/s.Step)
+}
+
+// Values lists every rating on the scale, lowest first.
+func (s RatingScale) Values() []float64 {
+	steps := int(math.Round((s.Max - s.Min) / s.Step))
+	values := make([]float64, 0, steps+1)
+	for i := 0; i <= steps; i++ {
+		values = append(values, s.Min+float64(i)*s.Step)
+	}
+	return values
+}
+
+func (s RatingScale) String() string {
+	return fmt.Sprintf("between %s and %s in steps of %s", formatRating(s.Min), formatRating(s.Max), formatRating(s.Step))
+}
+
+func isWhole(f float64) bool {
+	return math.Abs(f-math.Round(f)) < 1e-9
+}
+
+func formatRating(f float64) string {
+	return strconv.FormatFloat(f, 'f', -1, 64)
+}
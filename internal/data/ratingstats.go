@@ -0,0 +1,113 @@
+package data
+
+import (
+	"context"
+	"math"
+	"time"
+)
+
+// RatingStats describes the distribution of a movie's ratings, for drawing
+// histograms and trend lines.
+type RatingStats struct {
+	MovieID           int64           `json:"movie_id"`
+	Scale             RatingScale     `json:"scale"`
+	AverageRating     float64         `json:"average_rating"`
+	RatingCount       int64           `json:"rating_count"`
+	Median            float64         `json:"median"`
+	StandardDeviation float64         `json:"standard_deviation"`
+	Histogram         []RatingBucket  `json:"histogram"`
+	Monthly           []MonthlyRating `json:"monthly"`
+}
+
+// RatingBucket counts the ratings of one value on the scale.
+type RatingBucket struct {
+	Rating float64 `json:"rating"`
+	Count  int64   `json:"count"`
+}
+
+// MonthlyRating summarises the ratings given in one calendar month.
+type MonthlyRating struct {
+	Month         string  `json:"month"`
+	RatingCount   int64   `json:"rating_count"`
+	AverageRating float64 `json:"average_rating"`
+}
+
+// GetRatingStats computes a movie's rating statistics, with a histogram
+// bucket for every value on the scale and monthly figures
+// for the given number of months up to and including the current one. It
+// returns sql.ErrNoRows when the movie doesn't exist.
+func (m *MovieModel) GetRatingStats(movieID int64, months int, scale RatingScale) (*RatingStats, error) {
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	stats := RatingStats{MovieID: movieID, Scale: scale}
+
+	query := `SELECT COALESCE(AVG(ratings.rating), 0), count(ratings.rating),
+	COALESCE(percentile_cont(0.5) WITHIN GROUP (ORDER BY ratings.rating), 0),
+	COALESCE(stddev_pop(ratings.rating), 0)
+	FROM movies
+	LEFT JOIN ratings ON ratings.movie_id = movies.id
+	WHERE movies.id = $1
+	GROUP BY movies.id`
+
+	err := m.DB.QueryRowContext(ctx, query, movieID).Scan(&stats.AverageRating, &stats.RatingCount, &stats.Median, &stats.StandardDeviation)
+	if err != nil {
+		return nil, err
+	}
+
+	query = `SELECT rating, count(*) FROM ratings WHERE movie_id = $1 GROUP BY rating`
+
+	rows, err := m.DB.QueryContext(ctx, query, movieID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	// Counts are keyed by step number, so that float rounding can't put a
+	// rating in the wrong bucket.
+	counts := map[int]int64{}
+	for rows.Next() {
+		var bucket RatingBucket
+		if err := rows.Scan(&bucket.Rating, &bucket.Count); err != nil {
+			return nil, err
+		}
+		counts[int(math.Round((bucket.Rating-scale.Min)/scale.Step))] += bucket.Count
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	stats.Histogram = []RatingBucket{}
+	for i, rating := range scale.Values() {
+		stats.Histogram = append(stats.Histogram, RatingBucket{Rating: rating, Count: counts[i]})
+	}
+
+	// Months without ratings are included with a zero count, so that the
+	// series has no gaps.
+	query = `SELECT to_char(months.month, 'YYYY-MM'), count(ratings.rating), COALESCE(AVG(ratings.rating), 0)
+	FROM generate_series(date_trunc('month', NOW()) - make_interval(months => $2::int - 1), date_trunc('month', NOW()), interval '1 month') AS months(month)
+	LEFT JOIN ratings ON ratings.movie_id = $1 AND date_trunc('month', ratings.created_at) = months.month
+	GROUP BY months.month
+	ORDER BY months.month`
+
+	rows, err = m.DB.QueryContext(ctx, query, movieID, months)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	stats.Monthly = []MonthlyRating{}
+	for rows.Next() {
+		var month MonthlyRating
+		if err := rows.Scan(&month.Month, &month.RatingCount, &month.AverageRating); err != nil {
+			return nil, err
+		}
+		stats.Monthly = append(stats.Monthly, month)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return &stats, nil
+}
@@ -0,0 +1,384 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+const (
+	DefaultSimilarityMinOverlap = 3
+	DefaultSimilarityNeighbours = 50
+)
+
+// contentBlendRatings is the number of users in common at which the rating
+// based similarity of two movies counts as much as their content similarity.
+const contentBlendRatings = 10
+
+// SimilarMovie is a movie similar to another one, either because the users
+// who rated both rated them the same way or because of what the movies are.
+type SimilarMovie struct {
+	Similarity float64 `json:"similarity"`
+	// RatingSimilarity and ContentSimilarity are the parts Similarity was
+	// blended from, only set for content mode.
+	RatingSimilarity  *float64 `json:"rating_similarity,omitempty"`
+	ContentSimilarity *float64 `json:"content_similarity,omitempty"`
+	CoRatings         int      `json:"co_ratings"`
+	Reasons           []string `json:"reasons"`
+	Movie             *Movie   `json:"movie"`
+}
+
+// Recommendation is a movie the user hasn't rated, with the rating they are
+// predicted to give it.
+type Recommendation struct {
+	PredictedRating float64 `json:"predicted_rating"`
+	// Neighbours is the number of movies the user rated that the
+	// prediction is based on.
+	Neighbours int    `json:"neighbours"`
+	Movie      *Movie `json:"movie"`
+}
+
+// RecommendationModel serves item based collaborative filtering
+// recommendations from the precomputed movie_similarities table.
+type RecommendationModel struct {
+	DB *sql.DB
+	// MinOverlap is the number of users who must have rated both movies
+	// before their similarity is trusted.
+	MinOverlap int
+	// Neighbours is the number of most similar movies kept per movie.
+	Neighbours int
+}
+
+// ComputeSimilarities rebuilds movie_similarities from the ratings table and
+// returns the number of pairs stored. Similarity is the adjusted cosine of
+// two movies over the users who rated both: each rating is centred on its
+// user's mean rating, so a harsh and a generous rater who agree on which
+// movie is better count as agreeing. Only positive similarities are kept.
+//
+// The rebuild runs in one transaction, so requests keep reading the previous
+// similarities until it commits rather than waiting for it.
+func (m RecommendationModel) ComputeSimilarities() (int64, error) {
+
+	query := `WITH centered AS (
+		SELECT user_id, movie_id, rating - avg(rating) OVER (PARTITION BY user_id) AS deviation
+		FROM ratings
+	), pairs AS (
+		SELECT a.movie_id, b.movie_id AS similar_movie_id,
+		sum(a.deviation * b.deviation) AS numerator,
+		sqrt(sum(a.deviation * a.deviation) * sum(b.deviation * b.deviation)) AS denominator,
+		count(*) AS co_ratings
+		FROM centered a
+		INNER JOIN centered b ON b.user_id = a.user_id AND b.movie_id <> a.movie_id
+		GROUP BY a.movie_id, b.movie_id
+		HAVING count(*) >= $1
+	), ranked AS (
+		SELECT movie_id, similar_movie_id, numerator / denominator AS similarity, co_ratings,
+		row_number() OVER (PARTITION BY movie_id ORDER BY numerator / denominator DESC, co_ratings DESC, similar_movie_id) AS rank
+		FROM pairs
+		WHERE denominator > 0 AND numerator > 0
+	)
+	INSERT INTO movie_similarities (movie_id, similar_movie_id, similarity, co_ratings)
+	SELECT movie_id, similar_movie_id, similarity, co_ratings FROM ranked WHERE rank <= $2`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Minute)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, `DELETE FROM movie_similarities`); err != nil {
+		return 0, err
+	}
+
+	result, err := tx.ExecContext(ctx, query, m.minOverlap(), m.neighbours())
+	if err != nil {
+		return 0, err
+	}
+	stored, err := result.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+
+	return stored, tx.Commit()
+}
+
+func (m RecommendationModel) minOverlap() int {
+	if m.MinOverlap < 1 {
+		return DefaultSimilarityMinOverlap
+	}
+	return m.MinOverlap
+}
+
+func (m RecommendationModel) neighbours() int {
+	if m.Neighbours < 1 {
+		return DefaultSimilarityNeighbours
+	}
+	return m.Neighbours
+}
+
+// Similar pages through the movies most similar to a movie.
+func (m RecommendationModel) Similar(movieID int64, filters Filters) ([]*SimilarMovie, Metadata, error) {
+
+	query := `SELECT count(*) OVER(), movie_similarities.similarity, movie_similarities.co_ratings,
+	movies.id, movies.created_at, movies.title, movies.year, movies.runtime, movies.genres, movies.version,
+	` + movieAverageRating + `, movies.rating_count
+	FROM movie_similarities
+	INNER JOIN movies ON movies.id = movie_similarities.similar_movie_id
+	WHERE movie_similarities.movie_id = $1
+	ORDER BY movie_similarities.similarity DESC, movie_similarities.co_ratings DESC, movies.id ASC LIMIT $2 OFFSET $3`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, movieID, filters.limit(), filters.offset())
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	defer rows.Close()
+
+	similar := []*SimilarMovie{}
+	totalRecords := 0
+
+	for rows.Next() {
+		var item SimilarMovie
+		var movie Movie
+
+		err := rows.Scan(
+			&totalRecords,
+			&item.Similarity,
+			&item.CoRatings,
+			&movie.ID,
+			&movie.CreatedAt,
+			&movie.Title,
+			&movie.Year,
+			&movie.Runtime,
+			pq.Array(&movie.Genres),
+			&movie.Version,
+			&movie.AverageRating,
+			&movie.RatingCount,
+		)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+		item.Movie = &movie
+		item.Reasons = []string{ratedSimilarly(item.CoRatings)}
+
+		similar = append(similar, &item)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	return similar, calculateMetadata(totalRecords, filters.Page, filters.PageSize), nil
+}
+
+// SimilarByContent pages through the movies most like a movie in content:
+// shared genres, shared cast and crew, and how close their release years and
+// runtimes are. Movies with enough ratings in common blend this with their
+// rating based similarity, so it works for new movies without ratings and
+// sharpens as ratings arrive. There are no keywords to compare yet.
+func (m RecommendationModel) SimilarByContent(movieID int64, filters Filters) ([]*SimilarMovie, Metadata, error) {
+
+	query := fmt.Sprintf(`WITH source AS (
+		SELECT movies.id, movies.year, movies.runtime, movies.genres,
+		ARRAY(SELECT DISTINCT person_id FROM credits WHERE credits.movie_id = movies.id) AS people
+		FROM movies WHERE movies.id = $1
+	), features AS (
+		SELECT movies.id,
+		ARRAY(SELECT unnest(movies.genres) INTERSECT SELECT unnest(source.genres) ORDER BY 1) AS shared_genres,
+		cardinality(ARRAY(SELECT unnest(movies.genres) UNION SELECT unnest(source.genres))) AS all_genres,
+		ARRAY(SELECT DISTINCT person_id FROM credits WHERE credits.movie_id = movies.id AND credits.person_id = ANY(source.people)) AS shared_people,
+		cardinality(ARRAY(SELECT person_id FROM credits WHERE credits.movie_id = movies.id UNION SELECT unnest(source.people))) AS all_people,
+		cardinality(source.people) > 0 AS has_people,
+		abs(movies.year - source.year) AS year_gap,
+		abs(movies.runtime - source.runtime) AS runtime_gap,
+		movie_similarities.similarity AS rating_similarity,
+		COALESCE(movie_similarities.co_ratings, 0) AS co_ratings
+		FROM movies
+		CROSS JOIN source
+		LEFT JOIN movie_similarities ON movie_similarities.movie_id = source.id AND movie_similarities.similar_movie_id = movies.id
+		WHERE movies.id <> source.id
+		AND (movies.genres && source.genres OR movie_similarities.similarity IS NOT NULL
+			OR EXISTS (SELECT 1 FROM credits WHERE credits.movie_id = movies.id AND credits.person_id = ANY(source.people)))
+	), scored AS (
+		SELECT features.*,
+		(0.5 * cardinality(shared_genres) / GREATEST(all_genres, 1)
+			+ 0.15 * GREATEST(0, 1 - year_gap / 20.0)
+			+ 0.1 * GREATEST(0, 1 - runtime_gap / 60.0)
+			+ CASE WHEN has_people THEN 0.25 * cardinality(shared_people) / GREATEST(all_people, 1) ELSE 0 END
+		) / CASE WHEN has_people THEN 1 ELSE 0.75 END AS content_similarity
+		FROM features
+	), blended AS (
+		SELECT scored.*,
+		CASE WHEN rating_similarity IS NULL THEN content_similarity
+		ELSE (co_ratings * rating_similarity + %d * content_similarity) / (co_ratings + %d) END AS similarity
+		FROM scored
+	)
+	SELECT count(*) OVER(), blended.similarity, blended.rating_similarity, blended.content_similarity, blended.co_ratings,
+	blended.shared_genres, ARRAY(SELECT name FROM people WHERE people.id = ANY(blended.shared_people) ORDER BY name),
+	blended.year_gap, blended.runtime_gap,
+	movies.id, movies.created_at, movies.title, movies.year, movies.runtime, movies.genres, movies.version,
+	%s, movies.rating_count
+	FROM blended
+	INNER JOIN movies ON movies.id = blended.id
+	ORDER BY blended.similarity DESC, movies.id ASC LIMIT $2 OFFSET $3`, contentBlendRatings, contentBlendRatings, movieAverageRating)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, movieID, filters.limit(), filters.offset())
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	defer rows.Close()
+
+	similar := []*SimilarMovie{}
+	totalRecords := 0
+
+	for rows.Next() {
+		var item SimilarMovie
+		var movie Movie
+		var ratingSimilarity sql.NullFloat64
+		var contentSimilarity float64
+		var sharedGenres, sharedPeople []string
+		var yearGap, runtimeGap int
+
+		err := rows.Scan(
+			&totalRecords,
+			&item.Similarity,
+			&ratingSimilarity,
+			&contentSimilarity,
+			&item.CoRatings,
+			pq.Array(&sharedGenres),
+			pq.Array(&sharedPeople),
+			&yearGap,
+			&runtimeGap,
+			&movie.ID,
+			&movie.CreatedAt,
+			&movie.Title,
+			&movie.Year,
+			&movie.Runtime,
+			pq.Array(&movie.Genres),
+			&movie.Version,
+			&movie.AverageRating,
+			&movie.RatingCount,
+		)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+		item.Movie = &movie
+		item.ContentSimilarity = &contentSimilarity
+		if ratingSimilarity.Valid {
+			item.RatingSimilarity = &ratingSimilarity.Float64
+		}
+
+		item.Reasons = []string{}
+		if len(sharedGenres) > 0 {
+			item.Reasons = append(item.Reasons, "Shares genres: "+strings.Join(sharedGenres, ", "))
+		}
+		if len(sharedPeople) > 0 {
+			item.Reasons = append(item.Reasons, "Shares cast or crew: "+strings.Join(sharedPeople, ", "))
+		}
+		switch {
+		case yearGap == 0:
+			item.Reasons = append(item.Reasons, "Released the same year")
+		case yearGap <= 5:
+			item.Reasons = append(item.Reasons, fmt.Sprintf("Released within %d years", yearGap))
+		}
+		if runtimeGap <= 15 {
+			item.Reasons = append(item.Reasons, fmt.Sprintf("Runtime within %d minutes", runtimeGap))
+		}
+		if ratingSimilarity.Valid {
+			item.Reasons = append(item.Reasons, ratedSimilarly(item.CoRatings))
+		}
+
+		similar = append(similar, &item)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	return similar, calculateMetadata(totalRecords, filters.Page, filters.PageSize), nil
+}
+
+func ratedSimilarly(coRatings int) string {
+	return fmt.Sprintf("Rated alike by the %d users who rated both", coRatings)
+}
+
+// ForUser pages through recommendations for a user. A movie's predicted
+// rating is the similarity weighted average of the user's ratings of its
+// neighbours. Movies the user already rated or saved to their watchlist are
+// left out.
+func (m RecommendationModel) ForUser(userID int64, filters Filters) ([]*Recommendation, Metadata, error) {
+
+	query := `SELECT count(*) OVER(), candidates.predicted_rating, candidates.neighbours,
+	movies.id, movies.created_at, movies.title, movies.year, movies.runtime, movies.genres, movies.version,
+	` + movieAverageRating + `, movies.rating_count
+	FROM (
+		SELECT movie_similarities.similar_movie_id AS movie_id,
+		sum(movie_similarities.similarity * ratings.rating) / sum(movie_similarities.similarity) AS predicted_rating,
+		sum(movie_similarities.similarity) AS support,
+		count(*) AS neighbours
+		FROM ratings
+		INNER JOIN movie_similarities ON movie_similarities.movie_id = ratings.movie_id
+		WHERE ratings.user_id = $1
+		AND NOT EXISTS (SELECT 1 FROM ratings rated WHERE rated.user_id = $1 AND rated.movie_id = movie_similarities.similar_movie_id)
+		AND NOT EXISTS (SELECT 1 FROM watchlists WHERE watchlists.user_id = $1 AND watchlists.movie_id = movie_similarities.similar_movie_id)
+		GROUP BY movie_similarities.similar_movie_id
+	) AS candidates
+	INNER JOIN movies ON movies.id = candidates.movie_id
+	ORDER BY candidates.predicted_rating DESC, candidates.support DESC, movies.id ASC LIMIT $2 OFFSET $3`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, userID, filters.limit(), filters.offset())
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	defer rows.Close()
+
+	recommendations := []*Recommendation{}
+	totalRecords := 0
+
+	for rows.Next() {
+		var recommendation Recommendation
+		var movie Movie
+
+		err := rows.Scan(
+			&totalRecords,
+			&recommendation.PredictedRating,
+			&recommendation.Neighbours,
+			&movie.ID,
+			&movie.CreatedAt,
+			&movie.Title,
+			&movie.Year,
+			&movie.Runtime,
+			pq.Array(&movie.Genres),
+			&movie.Version,
+			&movie.AverageRating,
+			&movie.RatingCount,
+		)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+		recommendation.Movie = &movie
+
+		recommendations = append(recommendations, &recommendation)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	return recommendations, calculateMetadata(totalRecords, filters.Page, filters.PageSize), nil
+}
@@ -0,0 +1,194 @@
+package data
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base32"
+	"errors"
+	"time"
+)
+
+var (
+	ErrRefreshTokenReused = errors.New("refresh token reused")
+)
+
+// NewRefresh issues the first refresh token of a new family. Every token
+// obtained by rotating it later shares the same family, which identifies
+// the sign in the way a session id does for opaque tokens.
+func (T *TokenModel) NewRefresh(userID int64, ttl time.Duration, userAgent, ip string) (*Token, error) {
+
+	token, err := generateToken(userID, ttl, ScopeRefresh)
+	if err != nil {
+		return nil, err
+	}
+	token.UserAgent = userAgent
+	token.IP = ip
+
+	familyBytes := make([]byte, 10)
+	if _, err := rand.Read(familyBytes); err != nil {
+		return nil, err
+	}
+	token.Family = base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(familyBytes)
+
+	err = T.Insert(token)
+
+	return token, err
+}
+
+// Rotate exchanges a refresh token for a new one in the same family. The old
+// token is kept but marked used; presenting it a second time means it has
+// leaked, so the whole family is revoked and ErrRefreshTokenReused returned.
+func (T *TokenModel) Rotate(tokenPlainText string, ttl time.Duration, userAgent, ip string) (*Token, error) {
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	tx, err := T.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	hash := sha256.Sum256([]byte(tokenPlainText))
+	old := Token{Hash: hash[:], Scope: ScopeRefresh}
+
+	query := `SELECT id, user_id, family, expiry, used_at FROM tokens WHERE hash = $1 AND scope = $2 FOR UPDATE`
+
+	var expiry, usedAt sql.NullTime
+	err = tx.QueryRowContext(ctx, query, old.Hash, ScopeRefresh).Scan(&old.ID, &old.UserId, &old.Family, &expiry, &usedAt)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrRecordNotFound
+		}
+		return nil, err
+	}
+
+	if usedAt.Valid {
+		_, err := tx.ExecContext(ctx, `DELETE FROM tokens WHERE family = $1 AND scope = $2`, old.Family, ScopeRefresh)
+		if err != nil {
+			return nil, err
+		}
+		if err := tx.Commit(); err != nil {
+			return nil, err
+		}
+		return nil, ErrRefreshTokenReused
+	}
+
+	if !expiry.Valid || !expiry.Time.After(time.Now()) {
+		return nil, ErrRecordNotFound
+	}
+
+	if _, err := tx.ExecContext(ctx, `UPDATE tokens SET used_at = NOW() WHERE id = $1`, old.ID); err != nil {
+		return nil, err
+	}
+
+	token, err := generateToken(old.UserId, ttl, ScopeRefresh)
+	if err != nil {
+		return nil, err
+	}
+	token.UserAgent = userAgent
+	token.IP = ip
+	token.Family = old.Family
+
+	if err := insertToken(ctx, tx, token); err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	return token, nil
+}
+
+// DeleteFamily revokes every refresh token issued for one sign in.
+func (T *TokenModel) DeleteFamily(userID int64, family string) error {
+
+	query := `DELETE FROM tokens WHERE user_id = $1 AND family = $2 AND scope = $3`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := T.DB.ExecContext(ctx, query, userID, family, ScopeRefresh)
+
+	return err
+}
+
+// GetRefreshSessionsForUser lists a user's sign ins in JWT mode, one per
+// refresh token family that still has a usable token, most recently
+// refreshed first. A session's ID is the id of its family's first token,
+// which stays the same across rotations. currentFamily marks the session
+// making the request.
+func (T *TokenModel) GetRefreshSessionsForUser(userID int64, currentFamily string) ([]*Session, error) {
+
+	query := `SELECT first.id, first.created_at, latest.created_at, latest.expiry, latest.user_agent, latest.ip, first.family
+	FROM (SELECT family, min(id) AS id, min(created_at) AS created_at FROM tokens
+		WHERE user_id = $1 AND scope = $2 GROUP BY family) AS first
+	INNER JOIN LATERAL (SELECT created_at, expiry, user_agent, ip FROM tokens
+		WHERE user_id = $1 AND scope = $2 AND family = first.family AND used_at IS NULL
+		ORDER BY id DESC LIMIT 1) AS latest ON true
+	WHERE latest.expiry > $3
+	ORDER BY latest.created_at DESC, first.id DESC`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := T.DB.QueryContext(ctx, query, userID, ScopeRefresh, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	sessions := []*Session{}
+	for rows.Next() {
+		var session Session
+		var family string
+		err := rows.Scan(&session.ID, &session.CreatedAt, &session.LastUsedAt, &session.Expiry, &session.UserAgent, &session.IP, &family)
+		if err != nil {
+			return nil, err
+		}
+		session.Current = family == currentFamily
+		sessions = append(sessions, &session)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return sessions, nil
+}
+
+// DeleteRefreshSession revokes the refresh token family whose session ID,
+// as listed by GetRefreshSessionsForUser, is sessionID. It returns the
+// family's name.
+func (T *TokenModel) DeleteRefreshSession(userID, sessionID int64) (string, error) {
+
+	query := `DELETE FROM tokens WHERE user_id = $1 AND scope = $2
+	AND family = (SELECT family FROM tokens WHERE id = $3 AND user_id = $1 AND scope = $2 AND family <> '')
+	RETURNING family`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := T.DB.QueryContext(ctx, query, userID, ScopeRefresh, sessionID)
+	if err != nil {
+		return "", err
+	}
+	defer rows.Close()
+
+	family := ""
+	for rows.Next() {
+		if err := rows.Scan(&family); err != nil {
+			return "", err
+		}
+	}
+	if err = rows.Err(); err != nil {
+		return "", err
+	}
+	if family == "" {
+		return "", ErrRecordNotFound
+	}
+
+	return family, nil
+}
@@ -0,0 +1,200 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// Review is the text explaining a user's rating of a movie. There is at most
+// one per rating, and deleting the rating deletes the review.
+type Review struct {
+	ID             int64      `json:"id"`
+	UserID         int64      `json:"user_id"`
+	UserName       string     `json:"user_name,omitempty"`
+	MovieID        int64      `json:"movie_id"`
+	Rating         float64    `json:"rating"`
+	Title          string     `json:"title" validate:"max=200"`
+	Body           string     `json:"body" validate:"required,min=1,max=10000"`
+	Spoiler        bool       `json:"spoiler"`
+	HelpfulVotes   int        `json:"helpful_votes"`
+	UnhelpfulVotes int        `json:"unhelpful_votes"`
+	MyVote         *string    `json:"my_vote,omitempty"`
+	Status         string     `json:"status"`
+	CreatedAt      time.Time  `json:"created_at"`
+	EditedAt       *time.Time `json:"edited_at"`
+	Version        int32      `json:"version"`
+}
+
+type ReviewModel struct {
+	DB *sql.DB
+}
+
+// Upsert writes the review for a rating, creating it or replacing the text
+// of an existing one. Replacing sets EditedAt. Held and hidden reviews keep
+// their status when they are edited, until a moderator acts. A published
+// review saved with StatusHeld is put in the moderation queue with the
+// filter's reasons.
+func (m ReviewModel) Upsert(review *Review, reasons []string) error {
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := upsertReview(ctx, tx, review, reasons); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func upsertReview(ctx context.Context, tx *sql.Tx, review *Review, reasons []string) error {
+
+	if review.Status == "" {
+		review.Status = StatusPublished
+	}
+
+	query := `INSERT INTO reviews (user_id, movie_id, title, body, spoiler, status) VALUES ($1, $2, $3, $4, $5, $6)
+	ON CONFLICT (user_id, movie_id) DO UPDATE
+	SET title = EXCLUDED.title, body = EXCLUDED.body, spoiler = EXCLUDED.spoiler, edited_at = NOW(), version = reviews.version + 1,
+	status = CASE WHEN reviews.status = 'published' THEN EXCLUDED.status ELSE reviews.status END
+	RETURNING id, helpful_votes, unhelpful_votes, created_at, edited_at, version, status,
+	(SELECT rating FROM ratings WHERE user_id = $1 AND movie_id = $2)`
+
+	args := []interface{}{review.UserID, review.MovieID, review.Title, review.Body, review.Spoiler, review.Status}
+	screened := review.Status
+
+	var editedAt sql.NullTime
+	err := tx.QueryRowContext(ctx, query, args...).Scan(&review.ID, &review.HelpfulVotes, &review.UnhelpfulVotes, &review.CreatedAt, &editedAt, &review.Version, &review.Status, &review.Rating)
+	if err != nil {
+		return err
+	}
+	if editedAt.Valid {
+		review.EditedAt = &editedAt.Time
+	}
+
+	if screened == StatusHeld && review.Status == StatusHeld {
+		if err := hold(ctx, tx, ContentReview, review.ID, reasons); err != nil {
+			return err
+		}
+	}
+
+	// Only new reviews go to the activity feed, not every edit.
+	if review.Version == 1 {
+		return recordEvent(ctx, tx, &Event{UserID: review.UserID, Kind: EventReview, MovieID: &review.MovieID})
+	}
+
+	return nil
+}
+
+func (m ReviewModel) Delete(userID, movieID int64) error {
+
+	query := `DELETE FROM reviews WHERE user_id = $1 AND movie_id = $2`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, userID, movieID)
+	if err != nil {
+		return err
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
+
+// reviewSortClauses maps the public sort values to ORDER BY clauses.
+var reviewSortClauses = map[string]string{
+	"newest":  "reviews.created_at DESC",
+	"oldest":  "reviews.created_at ASC",
+	"highest": "ratings.rating DESC, reviews.created_at DESC",
+	"lowest":  "ratings.rating ASC, reviews.created_at DESC",
+	"helpful": "reviews.helpful_score DESC, reviews.helpful_votes DESC, reviews.created_at DESC",
+}
+
+// ListForMovie pages through a movie's published reviews. When viewerID is a
+// signed in user, each review also reports that user's own vote.
+func (m ReviewModel) ListForMovie(movieID, viewerID int64, filters Filters) ([]*Review, Metadata, error) {
+
+	if !filters.ValidSort() {
+		panic("unsafe sort parameter: " + filters.Sort)
+	}
+	orderBy, ok := reviewSortClauses[filters.Sort]
+	if !ok {
+		panic("unsafe sort parameter: " + filters.Sort)
+	}
+
+	query := fmt.Sprintf(`SELECT count(*) OVER(), reviews.id, reviews.user_id, users.name, reviews.movie_id, ratings.rating,
+	reviews.title, reviews.body, reviews.spoiler, reviews.helpful_votes, reviews.unhelpful_votes,
+	reviews.created_at, reviews.edited_at, reviews.version, reviews.status, review_votes.helpful
+	FROM reviews
+	INNER JOIN ratings ON ratings.user_id = reviews.user_id AND ratings.movie_id = reviews.movie_id
+	INNER JOIN users ON users.id = reviews.user_id
+	LEFT JOIN review_votes ON review_votes.review_id = reviews.id AND review_votes.user_id = $4
+	WHERE reviews.movie_id = $1 AND reviews.status = 'published'
+	ORDER BY %s, reviews.id ASC LIMIT $2 OFFSET $3`, orderBy)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, movieID, filters.limit(), filters.offset(), viewerID)
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	defer rows.Close()
+
+	reviews := []*Review{}
+	totalRecords := 0
+
+	for rows.Next() {
+		var review Review
+		var editedAt sql.NullTime
+		var vote sql.NullBool
+
+		err := rows.Scan(
+			&totalRecords,
+			&review.ID,
+			&review.UserID,
+			&review.UserName,
+			&review.MovieID,
+			&review.Rating,
+			&review.Title,
+			&review.Body,
+			&review.Spoiler,
+			&review.HelpfulVotes,
+			&review.UnhelpfulVotes,
+			&review.CreatedAt,
+			&editedAt,
+			&review.Version,
+			&review.Status,
+			&vote,
+		)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+		if editedAt.Valid {
+			review.EditedAt = &editedAt.Time
+		}
+		review.MyVote = voteName(vote)
+
+		reviews = append(reviews, &review)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	return reviews, calculateMetadata(totalRecords, filters.Page, filters.PageSize), nil
+}
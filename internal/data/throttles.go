@@ -0,0 +1,103 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+)
+
+// LoginThrottleModel counts failed sign in attempts per key, such as an
+// email address or a client IP, and locks a key out with exponential
+// backoff once it passes a threshold. Failures older than a day are
+// forgotten.
+type LoginThrottleModel struct {
+	DB *sql.DB
+}
+
+// ThrottleClaim is an attempt counted against a key before its outcome is
+// known.
+type ThrottleClaim struct {
+	Key string
+	// Failures includes this attempt. The attempt that reaches the
+	// threshold starts the lockout.
+	Failures int
+	// LockedUntil is the end of the key's lockout for a refused claim, and
+	// otherwise the lockout this attempt starts, or the zero time if none.
+	LockedUntil time.Time
+	Refused     bool
+}
+
+// Claim counts an attempt for key as a failure before the credentials are
+// checked, so a burst of concurrent attempts can't all pass the lockout
+// check before any of them is recorded. Reaching threshold locks the key for
+// base, and every further failure doubles the lockout up to max. While the
+// key is locked the claim is refused and not counted. A claim whose attempt
+// succeeds is forgiven with Release or Reset.
+func (m LoginThrottleModel) Claim(key string, threshold int, base, max time.Duration) (*ThrottleClaim, error) {
+
+	failures := `CASE WHEN login_throttles.updated_at < NOW() - INTERVAL '24 hours' THEN 1 ELSE login_throttles.failures + 1 END`
+
+	query := `INSERT INTO login_throttles (key, failures, locked_until)
+	VALUES ($1, 1, CASE WHEN 1 >= $2 THEN NOW() + LEAST($3 * power(2, 1 - $2), $4) * INTERVAL '1 second' END)
+	ON CONFLICT (key) DO UPDATE SET
+	failures = ` + failures + `,
+	locked_until = CASE WHEN ` + failures + ` >= $2
+		THEN NOW() + LEAST($3 * power(2, LEAST(` + failures + ` - $2, 30)), $4) * INTERVAL '1 second' END,
+	updated_at = NOW()
+	WHERE login_throttles.locked_until IS NULL OR login_throttles.locked_until <= NOW()
+	RETURNING failures, locked_until`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	claim := &ThrottleClaim{Key: key}
+	var lockedUntil sql.NullTime
+
+	err := m.DB.QueryRowContext(ctx, query, key, threshold, base.Seconds(), max.Seconds()).Scan(&claim.Failures, &lockedUntil)
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		// The conflicting row is locked, so nothing was updated.
+		claim.Refused = true
+		err = m.DB.QueryRowContext(ctx, `SELECT locked_until FROM login_throttles WHERE key = $1`, key).Scan(&lockedUntil)
+		if err != nil {
+			return nil, err
+		}
+	case err != nil:
+		return nil, err
+	}
+	claim.LockedUntil = lockedUntil.Time
+
+	return claim, nil
+}
+
+// Release forgives a claim whose attempt succeeded, lifting the lockout it
+// started. Attempts claimed after it were refused, so a lockout that still
+// matches the claim's is the claim's own.
+func (m LoginThrottleModel) Release(claim *ThrottleClaim) error {
+
+	query := `UPDATE login_throttles SET failures = GREATEST(failures - 1, 0),
+	locked_until = CASE WHEN locked_until = $2 THEN NULL ELSE locked_until END
+	WHERE key = $1`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	lockedUntil := sql.NullTime{Time: claim.LockedUntil, Valid: !claim.LockedUntil.IsZero()}
+	_, err := m.DB.ExecContext(ctx, query, claim.Key, lockedUntil)
+
+	return err
+}
+
+// Reset forgets every failure and lockout for key.
+func (m LoginThrottleModel) Reset(key string) error {
+
+	query := `DELETE FROM login_throttles WHERE key = $1`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, key)
+
+	return err
+}
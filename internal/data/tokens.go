@@ -12,6 +12,10 @@ import (
 const (
 	ScopeActivation     = "activation"
 	ScopeAuthentication = "authentication"
+	ScopePasswordReset  = "password-reset"
+	ScopeAPIKey         = "api-key"
+	ScopeRefresh        = "refresh"
+	ScopeMFAPending     = "mfa-pending"
 )
 
 type Token struct {
@@ -20,6 +24,24 @@ type Token struct {
 	UserId    int64     `json:"-"`
 	Expiry    time.Time `json:"expiry"`
 	Scope     string    `json:"-"`
+	ID        int64     `json:"-"`
+	CreatedAt time.Time `json:"-"`
+	UserAgent string    `json:"-"`
+	IP        string    `json:"-"`
+	Name      string    `json:"-"`
+	Family    string    `json:"-"`
+}
+
+// Session is the client-facing view of an authentication token. It never
+// carries the token itself, only what a user needs to recognise a device.
+type Session struct {
+	ID         int64     `json:"id"`
+	CreatedAt  time.Time `json:"created_at"`
+	LastUsedAt time.Time `json:"last_used_at"`
+	Expiry     time.Time `json:"expiry"`
+	UserAgent  string    `json:"user_agent"`
+	IP         string    `json:"ip"`
+	Current    bool      `json:"current"`
 }
 
 func generateToken(userId int64, ttl time.Duration, scope string) (*Token, error) {
@@ -61,18 +83,48 @@ func (T *TokenModel) New(userId int64, ttl time.Duration, scope string) (*Token,
 	return token, err
 }
 
-func (T *TokenModel) Insert(token *Token) error {
+// NewSession creates an authentication token tagged with the device it was
+// issued to, so that it can later be listed and revoked on its own.
+func (T *TokenModel) NewSession(userId int64, ttl time.Duration, userAgent, ip string) (*Token, error) {
 
-	query := `INSERT INTO tokens(hash,user_id,expiry,scope) VALUES ($1,$2,$3,$4)`
+	token, err := generateToken(userId, ttl, ScopeAuthentication)
+	if err != nil {
+		return nil, err
+	}
+	token.UserAgent = userAgent
+	token.IP = ip
 
-	args := []interface{}{token.Hash, token.UserId, token.Expiry, token.Scope}
+	err = T.Insert(token)
+
+	return token, err
+}
+
+func (T *TokenModel) Insert(token *Token) error {
 
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
-	_, err := T.DB.ExecContext(ctx, query, args...)
+	return insertToken(ctx, T.DB, token)
+}
 
-	return err
+type queryRower interface {
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+}
+
+// insertToken stores a token using either the pool or a transaction. A zero
+// Expiry is stored as NULL, meaning the token never expires.
+func insertToken(ctx context.Context, db queryRower, token *Token) error {
+
+	query := `INSERT INTO tokens(hash,user_id,expiry,scope,user_agent,ip,name,family) VALUES ($1,$2,$3,$4,$5,$6,$7,$8) RETURNING id, created_at`
+
+	var expiry interface{}
+	if !token.Expiry.IsZero() {
+		expiry = token.Expiry
+	}
+
+	args := []interface{}{token.Hash, token.UserId, expiry, token.Scope, token.UserAgent, token.IP, token.Name, token.Family}
+
+	return db.QueryRowContext(ctx, query, args...).Scan(&token.ID, &token.CreatedAt)
 }
 
 func (T *TokenModel) DeleteAllForUser(userID int64, scope string) error {
@@ -86,3 +138,75 @@ func (T *TokenModel) DeleteAllForUser(userID int64, scope string) error {
 
 	return err
 }
+
+// DeleteForUser revokes a single token of the given scope. The user id is
+// part of the condition so that users can only revoke their own tokens.
+func (T *TokenModel) DeleteForUser(userID, tokenID int64, scope string) error {
+
+	query := `DELETE FROM tokens WHERE id=$1 AND user_id=$2 AND scope=$3`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := T.DB.ExecContext(ctx, query, tokenID, userID, scope)
+	if err != nil {
+		return err
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
+
+// Touch records that a token has just been used. It writes at most once a
+// minute per token so that busy clients don't turn every request into a write.
+func (T *TokenModel) Touch(tokenID int64) error {
+
+	query := `UPDATE tokens SET last_used_at = NOW() WHERE id = $1 AND last_used_at < NOW() - INTERVAL '1 minute'`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := T.DB.ExecContext(ctx, query, tokenID)
+
+	return err
+}
+
+// GetSessionsForUser lists the unexpired authentication tokens of a user,
+// most recently used first. currentID marks the session making the request.
+func (T *TokenModel) GetSessionsForUser(userID, currentID int64) ([]*Session, error) {
+
+	query := `SELECT id, created_at, last_used_at, expiry, user_agent, ip FROM tokens
+	WHERE user_id = $1 AND scope = $2 AND expiry > $3
+	ORDER BY last_used_at DESC, id DESC`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := T.DB.QueryContext(ctx, query, userID, ScopeAuthentication, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	sessions := []*Session{}
+	for rows.Next() {
+		var session Session
+		err := rows.Scan(&session.ID, &session.CreatedAt, &session.LastUsedAt, &session.Expiry, &session.UserAgent, &session.IP)
+		if err != nil {
+			return nil, err
+		}
+		session.Current = session.ID == currentID
+		sessions = append(sessions, &session)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return sessions, nil
+}
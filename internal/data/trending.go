@@ -0,0 +1,179 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+const (
+	TrendingView      = "view"
+	TrendingRating    = "rating"
+	TrendingReview    = "review"
+	TrendingWatchlist = "watchlist"
+)
+
+// TrendingBucket is the granularity movie activity is counted at.
+const TrendingBucket = time.Hour
+
+// TrendingWindows maps the public window values to how far back activity is
+// counted. Within a window, activity loses half its weight every quarter of
+// the window, so a movie busy right now outranks one that was busy at the
+// start of the window.
+var TrendingWindows = map[string]time.Duration{
+	"day":   24 * time.Hour,
+	"week":  7 * 24 * time.Hour,
+	"month": 30 * 24 * time.Hour,
+}
+
+// trendingRetention is how long activity is kept, the longest window plus a
+// day of slack.
+const trendingRetention = 31 * 24 * time.Hour
+
+// trendingWeights is how much each kind of activity counts towards a movie's
+// trending score. Rating or reviewing a movie says more than opening it.
+const trendingWeights = `CASE kind WHEN 'review' THEN 5 WHEN 'rating' THEN 4 WHEN 'watchlist' THEN 3 ELSE 1 END`
+
+// TrendingKey identifies a counter of one kind of activity on a movie during
+// one bucket.
+type TrendingKey struct {
+	MovieID int64
+	Kind    string
+	Bucket  time.Time
+}
+
+// TrendingMovie is a movie with its trending score and the activity in the
+// window behind it.
+type TrendingMovie struct {
+	Score         float64 `json:"score"`
+	Views         int     `json:"views"`
+	Ratings       int     `json:"ratings"`
+	Reviews       int     `json:"reviews"`
+	WatchlistAdds int     `json:"watchlist_adds"`
+	Movie         *Movie  `json:"movie"`
+}
+
+type TrendingModel struct {
+	DB *sql.DB
+}
+
+// AddCounts adds counted activity to the stored counters in one statement,
+// and drops activity older than every window. Counts for movies that no
+// longer exist are ignored.
+func (m TrendingModel) AddCounts(counts map[TrendingKey]int) error {
+
+	movieIDs := make([]int64, 0, len(counts))
+	kinds := make([]string, 0, len(counts))
+	buckets := make([]string, 0, len(counts))
+	values := make([]int64, 0, len(counts))
+	for key, count := range counts {
+		movieIDs = append(movieIDs, key.MovieID)
+		kinds = append(kinds, key.Kind)
+		buckets = append(buckets, key.Bucket.UTC().Format(time.RFC3339))
+		values = append(values, int64(count))
+	}
+
+	query := `INSERT INTO movie_activity (movie_id, kind, bucket, count)
+	SELECT counts.movie_id, counts.kind, counts.bucket, counts.count
+	FROM unnest($1::bigint[], $2::text[], $3::timestamptz[], $4::integer[]) AS counts(movie_id, kind, bucket, count)
+	INNER JOIN movies ON movies.id = counts.movie_id
+	ON CONFLICT (movie_id, kind, bucket) DO UPDATE SET count = movie_activity.count + EXCLUDED.count`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if len(counts) > 0 {
+		_, err = tx.ExecContext(ctx, query, pq.Array(movieIDs), pq.Array(kinds), pq.Array(buckets), pq.Array(values))
+		if err != nil {
+			return err
+		}
+	}
+
+	_, err = tx.ExecContext(ctx, `DELETE FROM movie_activity WHERE bucket < $1`, time.Now().Add(-trendingRetention))
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// Trending pages through the movies with activity in the window, ranked by
+// their time decayed score.
+func (m TrendingModel) Trending(window time.Duration, filters Filters) ([]*TrendingMovie, Metadata, error) {
+
+	query := fmt.Sprintf(`SELECT count(*) OVER(), trending.score, trending.views, trending.ratings, trending.reviews, trending.watchlist_adds,
+	movies.id, movies.created_at, movies.title, movies.year, movies.runtime, movies.genres, movies.version,
+	%s, movies.rating_count
+	FROM (
+		SELECT movie_id,
+		sum(%s * count * power(0.5, extract(epoch FROM NOW() - bucket)::float8 / $2::float8)) AS score,
+		COALESCE(sum(count) FILTER (WHERE kind = 'view'), 0) AS views,
+		COALESCE(sum(count) FILTER (WHERE kind = 'rating'), 0) AS ratings,
+		COALESCE(sum(count) FILTER (WHERE kind = 'review'), 0) AS reviews,
+		COALESCE(sum(count) FILTER (WHERE kind = 'watchlist'), 0) AS watchlist_adds
+		FROM movie_activity
+		WHERE bucket > $1
+		GROUP BY movie_id
+	) AS trending
+	INNER JOIN movies ON movies.id = trending.movie_id
+	ORDER BY trending.score DESC, movies.id ASC LIMIT $3 OFFSET $4`, movieAverageRating, trendingWeights)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	since := time.Now().Add(-window)
+	halfLife := (window / 4).Seconds()
+
+	rows, err := m.DB.QueryContext(ctx, query, since, halfLife, filters.limit(), filters.offset())
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	defer rows.Close()
+
+	movies := []*TrendingMovie{}
+	totalRecords := 0
+
+	for rows.Next() {
+		var trending TrendingMovie
+		var movie Movie
+
+		err := rows.Scan(
+			&totalRecords,
+			&trending.Score,
+			&trending.Views,
+			&trending.Ratings,
+			&trending.Reviews,
+			&trending.WatchlistAdds,
+			&movie.ID,
+			&movie.CreatedAt,
+			&movie.Title,
+			&movie.Year,
+			&movie.Runtime,
+			pq.Array(&movie.Genres),
+			&movie.Version,
+			&movie.AverageRating,
+			&movie.RatingCount,
+		)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+		trending.Movie = &movie
+
+		movies = append(movies, &trending)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	return movies, calculateMetadata(totalRecords, filters.Page, filters.PageSize), nil
+}
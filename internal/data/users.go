@@ -6,9 +6,11 @@ import (
 	"database/sql"
 	"errors"
 	"log"
+	"sync"
 	"time"
 
 	"github.com/go-playground/validator/v10"
+	"github.com/lib/pq"
 	"golang.org/x/crypto/bcrypt"
 )
 
@@ -70,6 +72,21 @@ func (p *password) Compare(plaintextPassword string) (bool, error) {
 
 }
 
+var (
+	dummyHash     []byte
+	dummyHashOnce sync.Once
+)
+
+// CompareDummyPassword spends as long as a real password check. It is used
+// when no user matches an email, so that response times don't reveal which
+// addresses have accounts.
+func CompareDummyPassword(plaintextPassword string) {
+	dummyHashOnce.Do(func() {
+		dummyHash, _ = bcrypt.GenerateFromPassword([]byte("dummy password for timing"), 12)
+	})
+	bcrypt.CompareHashAndPassword(dummyHash, []byte(plaintextPassword))
+}
+
 func (u *UserModel) Insert(user *User) error {
 	query := `INSERT INTO users (name, email, password_hash, activated)
 VALUES ($1, $2, $3, $4)
@@ -112,6 +129,30 @@ func (m UserModel) GetByEmail(email string) (*User, error) {
 	return &user, nil
 }
 
+func (m UserModel) Get(id int64) (*User, error) {
+	query := `
+	SELECT id, created_at, name, email, password_hash, activated, version
+	FROM users
+	WHERE id = $1`
+	var user User
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	err := m.DB.QueryRowContext(ctx, query, id).Scan(
+		&user.ID,
+		&user.CreatedAt,
+		&user.Name,
+		&user.Email,
+		&user.Password.Hash,
+		&user.Activated,
+		&user.Version,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return &user, nil
+}
+
 func (m UserModel) Update(user *User) error {
 	query := `
 		UPDATE users
@@ -140,7 +181,7 @@ func (m UserModel) Update(user *User) error {
 func (m UserModel) GetForToken(tokenScope, tokenPlainText string) (*User, error) {
 	tokenHash := sha256.Sum256([]byte(tokenPlainText))
 
-	query := `SELECT users.id, users.created_at, users.name, users.email, users.password_hash, users.activated, users.version FROM users INNER JOIN tokens ON users.id = tokens.user_id WHERE tokens.hash = $1 AND tokens.scope = $2 AND tokens.expiry > $3`
+	query := `SELECT users.id, users.created_at, users.name, users.email, users.password_hash, users.activated, users.version FROM users INNER JOIN tokens ON users.id = tokens.user_id WHERE tokens.hash = $1 AND tokens.scope = $2 AND (tokens.expiry IS NULL OR tokens.expiry > $3)`
 
 	args := []interface{}{tokenHash[:], tokenScope, time.Now()}
 
@@ -163,3 +204,50 @@ func (m UserModel) GetForToken(tokenScope, tokenPlainText string) (*User, error)
 
 	return &user, nil
 }
+
+// GetWithToken is GetForToken for callers that also need the token row,
+// such as the authenticate middleware which tracks the current session. The
+// token may belong to any of the given scopes; Token.Scope reports which.
+func (m UserModel) GetWithToken(tokenPlainText string, tokenScopes ...string) (*User, *Token, error) {
+	tokenHash := sha256.Sum256([]byte(tokenPlainText))
+
+	query := `SELECT users.id, users.created_at, users.name, users.email, users.password_hash, users.activated, users.version,
+	tokens.id, tokens.scope, tokens.expiry, tokens.created_at, tokens.user_agent, tokens.ip, tokens.name
+	FROM users INNER JOIN tokens ON users.id = tokens.user_id
+	WHERE tokens.hash = $1 AND tokens.scope = ANY($2) AND (tokens.expiry IS NULL OR tokens.expiry > $3)`
+
+	args := []interface{}{tokenHash[:], pq.Array(tokenScopes), time.Now()}
+
+	var user User
+	var expiry sql.NullTime
+	token := Token{
+		Plaintext: tokenPlainText,
+		Hash:      tokenHash[:],
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, args...).Scan(
+		&user.ID,
+		&user.CreatedAt,
+		&user.Name,
+		&user.Email,
+		&user.Password.Hash,
+		&user.Activated,
+		&user.Version,
+		&token.ID,
+		&token.Scope,
+		&expiry,
+		&token.CreatedAt,
+		&token.UserAgent,
+		&token.IP,
+		&token.Name,
+	)
+	if err != nil {
+		return nil, nil, err
+	}
+	token.UserId = user.ID
+	token.Expiry = expiry.Time
+
+	return &user, &token, nil
+}
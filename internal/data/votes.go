@@ -0,0 +1,143 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"math"
+	"time"
+)
+
+var (
+	ErrOwnReview = errors.New("cannot vote on own review")
+)
+
+const (
+	VoteHelpful   = "helpful"
+	VoteUnhelpful = "unhelpful"
+)
+
+// wilsonLowerBound is the lower bound of the 95% Wilson score interval for
+// the share of helpful votes. Unlike the raw count or ratio, it ranks a
+// review with 90 of 100 helpful votes above one with 1 of 1.
+func wilsonLowerBound(helpful, unhelpful int) float64 {
+	n := float64(helpful + unhelpful)
+	if n == 0 {
+		return 0
+	}
+
+	const z = 1.96
+	phat := float64(helpful) / n
+
+	return (phat + z*z/(2*n) - z*math.Sqrt((phat*(1-phat)+z*z/(4*n))/n)) / (1 + z*z/n)
+}
+
+// Vote records a user's helpful or unhelpful vote on a review, replacing any
+// earlier vote of theirs, and refreshes the review's counts and score.
+func (m ReviewModel) Vote(reviewID, userID int64, helpful bool) (*Review, error) {
+	return m.changeVote(reviewID, userID, func(ctx context.Context, tx *sql.Tx) error {
+		query := `INSERT INTO review_votes (review_id, user_id, helpful) VALUES ($1, $2, $3)
+		ON CONFLICT (review_id, user_id) DO UPDATE SET helpful = EXCLUDED.helpful, updated_at = NOW()`
+
+		_, err := tx.ExecContext(ctx, query, reviewID, userID, helpful)
+		return err
+	})
+}
+
+// RemoveVote withdraws a user's vote on a review.
+func (m ReviewModel) RemoveVote(reviewID, userID int64) (*Review, error) {
+	return m.changeVote(reviewID, userID, func(ctx context.Context, tx *sql.Tx) error {
+		result, err := tx.ExecContext(ctx, `DELETE FROM review_votes WHERE review_id = $1 AND user_id = $2`, reviewID, userID)
+		if err != nil {
+			return err
+		}
+		rowsAffected, err := result.RowsAffected()
+		if err != nil {
+			return err
+		}
+		if rowsAffected == 0 {
+			return ErrRecordNotFound
+		}
+		return nil
+	})
+}
+
+func (m ReviewModel) changeVote(reviewID, userID int64, change func(ctx context.Context, tx *sql.Tx) error) (*Review, error) {
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	// Locking the review serialises concurrent votes on it, so the counts
+	// written below can't be computed from a stale view.
+	var authorID int64
+	err = tx.QueryRowContext(ctx, `SELECT user_id FROM reviews WHERE id = $1 AND status = $2 FOR UPDATE`, reviewID, StatusPublished).Scan(&authorID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrRecordNotFound
+		}
+		return nil, err
+	}
+	if authorID == userID {
+		return nil, ErrOwnReview
+	}
+
+	if err := change(ctx, tx); err != nil {
+		return nil, err
+	}
+
+	review := Review{ID: reviewID}
+
+	query := `SELECT count(*) FILTER (WHERE helpful), count(*) FILTER (WHERE NOT helpful) FROM review_votes WHERE review_id = $1`
+	if err := tx.QueryRowContext(ctx, query, reviewID).Scan(&review.HelpfulVotes, &review.UnhelpfulVotes); err != nil {
+		return nil, err
+	}
+
+	query = `UPDATE reviews SET helpful_votes = $2, unhelpful_votes = $3, helpful_score = $4 WHERE id = $1
+	RETURNING user_id, movie_id, title, body, spoiler, status, created_at, edited_at, version`
+
+	var editedAt sql.NullTime
+	err = tx.QueryRowContext(ctx, query, reviewID, review.HelpfulVotes, review.UnhelpfulVotes, wilsonLowerBound(review.HelpfulVotes, review.UnhelpfulVotes)).Scan(
+		&review.UserID, &review.MovieID, &review.Title, &review.Body, &review.Spoiler, &review.Status, &review.CreatedAt, &editedAt, &review.Version)
+	if err != nil {
+		return nil, err
+	}
+	if editedAt.Valid {
+		review.EditedAt = &editedAt.Time
+	}
+
+	var vote sql.NullBool
+	err = tx.QueryRowContext(ctx, `SELECT helpful FROM review_votes WHERE review_id = $1 AND user_id = $2`, reviewID, userID).Scan(&vote)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return nil, err
+	}
+	review.MyVote = voteName(vote)
+
+	err = tx.QueryRowContext(ctx, `SELECT ratings.rating, users.name FROM ratings INNER JOIN users ON users.id = ratings.user_id
+	WHERE ratings.user_id = $1 AND ratings.movie_id = $2`, review.UserID, review.MovieID).Scan(&review.Rating, &review.UserName)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	return &review, nil
+}
+
+func voteName(vote sql.NullBool) *string {
+	if !vote.Valid {
+		return nil
+	}
+	name := VoteUnhelpful
+	if vote.Bool {
+		name = VoteHelpful
+	}
+	return &name
+}
@@ -0,0 +1,155 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// WatchlistEntry is a movie a user saved to watch later.
+type WatchlistEntry struct {
+	AddedAt time.Time `json:"added_at"`
+	Movie   *Movie    `json:"movie"`
+}
+
+type WatchlistModel struct {
+	DB        *sql.DB
+	Weighting RatingWeighting
+}
+
+// Add saves a movie to a user's watchlist and reports whether it wasn't
+// there already. Adding a movie that is already there is not an error, and
+// keeps its original AddedAt. It returns ErrRecordNotFound when the movie
+// doesn't exist.
+func (m WatchlistModel) Add(userID, movieID int64) (*WatchlistEntry, bool, error) {
+
+	query := `WITH inserted AS (
+		INSERT INTO watchlists (user_id, movie_id) VALUES ($1, $2)
+		ON CONFLICT (user_id, movie_id) DO NOTHING
+		RETURNING added_at
+	)
+	SELECT added_at, true FROM inserted
+	UNION ALL
+	SELECT added_at, false FROM watchlists WHERE user_id = $1 AND movie_id = $2`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	entry := WatchlistEntry{Movie: &Movie{ID: movieID}}
+
+	var added bool
+	err := m.DB.QueryRowContext(ctx, query, userID, movieID).Scan(&entry.AddedAt, &added)
+	if err != nil {
+		var pqErr *pq.Error
+		if errors.As(err, &pqErr) && pqErr.Code.Name() == "foreign_key_violation" {
+			return nil, false, ErrRecordNotFound
+		}
+		return nil, false, err
+	}
+
+	return &entry, added, nil
+}
+
+func (m WatchlistModel) Remove(userID, movieID int64) error {
+
+	query := `DELETE FROM watchlists WHERE user_id = $1 AND movie_id = $2`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, userID, movieID)
+	if err != nil {
+		return err
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
+
+// Contains reports whether a movie is on a user's watchlist.
+func (m WatchlistModel) Contains(userID, movieID int64) (bool, error) {
+
+	query := `SELECT EXISTS (SELECT 1 FROM watchlists WHERE user_id = $1 AND movie_id = $2)`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var exists bool
+	err := m.DB.QueryRowContext(ctx, query, userID, movieID).Scan(&exists)
+
+	return exists, err
+}
+
+// List pages through a user's watchlist. It takes the same genre filter and
+// sort values as MovieModel.List, plus "added" for the time a movie was saved.
+func (m WatchlistModel) List(userID int64, genres []string, filters Filters) ([]*WatchlistEntry, Metadata, error) {
+
+	sortColumn := "movies." + filters.sortColumn()
+	if column, ok := movieSortColumns[filters.sortColumn()]; ok {
+		sortColumn = column
+	} else if filters.sortColumn() == "added" {
+		sortColumn = "watchlists.added_at"
+	}
+
+	query := fmt.Sprintf(`SELECT count(*) OVER(), watchlists.added_at,
+	movies.id, movies.created_at, movies.title, movies.year, movies.runtime, movies.genres, movies.version,
+	%s AS average_rating, movies.rating_count, %s AS weighted_rating
+	FROM watchlists
+	INNER JOIN movies ON movies.id = watchlists.movie_id
+	WHERE watchlists.user_id = $1 AND (movies.genres @> $2 OR $2 = '{}')
+	ORDER BY %s %s, movies.id ASC LIMIT $3 OFFSET $4`, movieAverageRating, m.Weighting.expression(), sortColumn, filters.sortDirection())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, userID, pq.Array(genres), filters.limit(), filters.offset())
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	defer rows.Close()
+
+	entries := []*WatchlistEntry{}
+	totalRecords := 0
+
+	for rows.Next() {
+		var entry WatchlistEntry
+		var movie Movie
+
+		err := rows.Scan(
+			&totalRecords,
+			&entry.AddedAt,
+			&movie.ID,
+			&movie.CreatedAt,
+			&movie.Title,
+			&movie.Year,
+			&movie.Runtime,
+			pq.Array(&movie.Genres),
+			&movie.Version,
+			&movie.AverageRating,
+			&movie.RatingCount,
+			&movie.WeightedRating,
+		)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+		entry.Movie = &movie
+
+		entries = append(entries, &entry)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	return entries, calculateMetadata(totalRecords, filters.Page, filters.PageSize), nil
+}
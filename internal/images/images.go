@@ -0,0 +1,143 @@
+// Package images validates uploaded images, strips their metadata and
+// resizes them into the variants served to clients.
+package images
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"image/jpeg"
+	"image/png"
+	"net/http"
+
+	_ "golang.org/x/image/webp"
+)
+
+var (
+	ErrUnsupportedFormat = errors.New("image must be a JPEG, PNG or WebP")
+	ErrInvalidImage      = errors.New("image could not be read")
+	ErrTooLarge          = errors.New("image dimensions are too large")
+)
+
+// maxPixels bounds the decoded size of an upload, so a small file claiming
+// huge dimensions can't exhaust memory.
+const maxPixels = 50_000_000
+
+// Original is the name of the full size variant.
+const Original = "original"
+
+// Size is a variant resized to Width, keeping the aspect ratio.
+type Size struct {
+	Name  string
+	Width int
+}
+
+var (
+	PosterSizes   = []Size{{"small", 185}, {"medium", 342}, {"large", 780}}
+	BackdropSizes = []Size{{"small", 300}, {"medium", 780}, {"large", 1280}}
+)
+
+// Variant is an encoded version of an upload.
+type Variant struct {
+	Name        string
+	Width       int
+	Height      int
+	ContentType string
+	Extension   string
+	Data        []byte
+}
+
+// Process checks that data is a JPEG, PNG or WebP by its content rather than
+// its file name, and returns the variants to store. The image is turned
+// upright according to its EXIF orientation and re-encoded, which drops EXIF
+// and every other metadata, then resized to each size smaller than the
+// image. Sizes the image is already smaller than are skipped rather than
+// upscaled.
+//
+// JPEG and PNG images keep their format. WebP can't be encoded with the
+// libraries available, so WebP images become JPEGs, or PNGs when they have
+// transparency.
+func Process(data []byte, sizes []Size) ([]*Variant, error) {
+	switch http.DetectContentType(data) {
+	case "image/jpeg":
+		return processRaster(data, "image/jpeg", sizes, jpegOrientation(data))
+	case "image/png":
+		return processRaster(data, "image/png", sizes, 1)
+	case "image/webp":
+		return processRaster(data, "", sizes, webpOrientation(data))
+	default:
+		return nil, ErrUnsupportedFormat
+	}
+}
+
+// processRaster decodes, orients and resizes an image, encoding the variants
+// as contentType, or as JPEG or PNG by whether the image is opaque when
+// contentType is empty.
+func processRaster(data []byte, contentType string, sizes []Size, orientation int) ([]*Variant, error) {
+	config, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, ErrInvalidImage
+	}
+	if config.Width < 1 || config.Height < 1 {
+		return nil, ErrInvalidImage
+	}
+	if config.Width*config.Height > maxPixels {
+		return nil, ErrTooLarge
+	}
+
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, ErrInvalidImage
+	}
+	img = orient(img, orientation)
+
+	if contentType == "" {
+		contentType = "image/png"
+		if opaque, ok := img.(interface{ Opaque() bool }); ok && opaque.Opaque() {
+			contentType = "image/jpeg"
+		}
+	}
+	extension := ".jpg"
+	if contentType == "image/png" {
+		extension = ".png"
+	}
+
+	encode := func(name string, img image.Image) (*Variant, error) {
+		var buf bytes.Buffer
+		var err error
+		if contentType == "image/png" {
+			err = png.Encode(&buf, img)
+		} else {
+			err = jpeg.Encode(&buf, img, &jpeg.Options{Quality: 85})
+		}
+		if err != nil {
+			return nil, err
+		}
+		bounds := img.Bounds()
+		return &Variant{Name: name, Width: bounds.Dx(), Height: bounds.Dy(), ContentType: contentType, Extension: extension, Data: buf.Bytes()}, nil
+	}
+
+	original, err := encode(Original, img)
+	if err != nil {
+		return nil, err
+	}
+	variants := []*Variant{original}
+
+	bounds := img.Bounds()
+	for _, size := range sizes {
+		if size.Width >= bounds.Dx() {
+			continue
+		}
+		height := (bounds.Dy()*size.Width + bounds.Dx()/2) / bounds.Dx()
+		if height < 1 {
+			height = 1
+		}
+		variant, err := encode(size.Name, resize(img, size.Width, height))
+		if err != nil {
+			return nil, err
+		}
+		variants = append(variants, variant)
+	}
+
+	return variants, nil
+}
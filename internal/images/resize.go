@@ -0,0 +1,86 @@
+package images
+
+import (
+	"image"
+	"image/draw"
+	"math"
+)
+
+// resize scales img to width by height by area averaging: each output pixel
+// is the average of the input pixels it covers, weighted by how much of each
+// it covers. That keeps downscaled images smooth without aliasing. Averaging
+// premultiplied colours keeps transparent edges from darkening.
+func resize(img image.Image, width, height int) *image.RGBA {
+	bounds := img.Bounds()
+	src := image.NewRGBA(image.Rect(0, 0, bounds.Dx(), bounds.Dy()))
+	draw.Draw(src, src.Bounds(), img, bounds.Min, draw.Src)
+
+	srcWidth, srcHeight := src.Bounds().Dx(), src.Bounds().Dy()
+	columns := coverage(srcWidth, width)
+	rows := coverage(srcHeight, height)
+
+	// Scale horizontally into tmp, then vertically into dst.
+	tmp := make([]float64, width*srcHeight*4)
+	for y := 0; y < srcHeight; y++ {
+		for x, c := range columns {
+			offset := (y*width + x) * 4
+			for i, weight := range c.weights {
+				p := src.PixOffset(c.first+i, y)
+				for ch := 0; ch < 4; ch++ {
+					tmp[offset+ch] += weight * float64(src.Pix[p+ch])
+				}
+			}
+		}
+	}
+
+	dst := image.NewRGBA(image.Rect(0, 0, width, height))
+	for y, r := range rows {
+		for x := 0; x < width; x++ {
+			var sum [4]float64
+			for i, weight := range r.weights {
+				offset := ((r.first+i)*width + x) * 4
+				for ch := 0; ch < 4; ch++ {
+					sum[ch] += weight * tmp[offset+ch]
+				}
+			}
+			p := dst.PixOffset(x, y)
+			for ch := 0; ch < 4; ch++ {
+				dst.Pix[p+ch] = uint8(math.Min(255, math.Max(0, math.Round(sum[ch]))))
+			}
+		}
+	}
+
+	return dst
+}
+
+// span is the run of input pixels an output pixel covers, starting at first,
+// with the share of the output pixel each one makes up.
+type span struct {
+	first   int
+	weights []float64
+}
+
+func coverage(from, to int) []span {
+	scale := float64(from) / float64(to)
+	spans := make([]span, to)
+
+	for i := range spans {
+		start := float64(i) * scale
+		end := math.Min(float64(i+1)*scale, float64(from))
+
+		first := int(start)
+		last := int(math.Ceil(end)) - 1
+		if last < first {
+			last = first
+		}
+
+		weights := make([]float64, last-first+1)
+		for j := range weights {
+			pixel := float64(first + j)
+			weights[j] = (math.Min(end, pixel+1) - math.Max(start, pixel)) / scale
+		}
+		spans[i] = span{first: first, weights: weights}
+	}
+
+	return spans
+}
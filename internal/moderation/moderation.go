@@ -0,0 +1,110 @@
+// Package moderation screens user submitted text before it is published.
+package moderation
+
+import (
+	"bufio"
+	"os"
+	"strings"
+	"unicode"
+)
+
+// Filter decides whether text must be held for a moderator. Check returns
+// the reasons for holding it, or nothing when the text can be published.
+type Filter interface {
+	Check(text ...string) []string
+}
+
+// Chain runs several filters and holds text when any of them does.
+type Chain []Filter
+
+func (c Chain) Check(text ...string) []string {
+	var reasons []string
+	for _, f := range c {
+		reasons = append(reasons, f.Check(text...)...)
+	}
+	return reasons
+}
+
+// WordFilter holds text containing any of a list of banned words. Words are
+// matched whole and case-insensitively, so "class" doesn't match "ass". A
+// banned entry of several words matches that exact sequence.
+type WordFilter struct {
+	phrases [][]string
+}
+
+// NewWordFilter builds a filter from banned words or phrases. Blank entries
+// are ignored.
+func NewWordFilter(words []string) *WordFilter {
+	f := &WordFilter{}
+	for _, w := range words {
+		if phrase := tokenize(w); len(phrase) > 0 {
+			f.phrases = append(f.phrases, phrase)
+		}
+	}
+	return f
+}
+
+// LoadWordFilter reads banned words from a file with one word or phrase per
+// line. Blank lines and lines starting with # are skipped.
+func LoadWordFilter(path string) (*WordFilter, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var words []string
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		words = append(words, line)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return NewWordFilter(words), nil
+}
+
+func (f *WordFilter) Check(text ...string) []string {
+	texts := make([][]string, len(text))
+	for i, t := range text {
+		texts[i] = tokenize(t)
+	}
+
+	var reasons []string
+	for _, phrase := range f.phrases {
+		for _, words := range texts {
+			if containsPhrase(words, phrase) {
+				reasons = append(reasons, "banned word: "+strings.Join(phrase, " "))
+				break
+			}
+		}
+	}
+	return reasons
+}
+
+func tokenize(text string) []string {
+	return strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
+
+func containsPhrase(words, phrase []string) bool {
+	for i := 0; i+len(phrase) <= len(words); i++ {
+		match := true
+		for j := range phrase {
+			if words[i+j] != phrase[j] {
+				match = false
+				break
+			}
+		}
+		if match {
+			return true
+		}
+	}
+	return false
+}
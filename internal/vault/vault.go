@@ -0,0 +1,53 @@
+// Package vault encrypts small secrets, such as TOTP seeds, before they are
+// stored in the database.
+package vault
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"errors"
+)
+
+type Vault struct {
+	aead cipher.AEAD
+}
+
+// New creates a Vault using AES-256-GCM with the given 32 byte key.
+func New(key []byte) (*Vault, error) {
+	if len(key) != 32 {
+		return nil, errors.New("vault: key must be 32 bytes")
+	}
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Vault{aead: aead}, nil
+}
+
+// Encrypt returns a random nonce followed by the sealed plaintext.
+func (v *Vault) Encrypt(plaintext []byte) ([]byte, error) {
+	nonce := make([]byte, v.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+
+	return v.aead.Seal(nonce, nonce, plaintext, nil), nil
+}
+
+func (v *Vault) Decrypt(ciphertext []byte) ([]byte, error) {
+	if len(ciphertext) < v.aead.NonceSize() {
+		return nil, errors.New("vault: ciphertext too short")
+	}
+
+	nonce, sealed := ciphertext[:v.aead.NonceSize()], ciphertext[v.aead.NonceSize():]
+
+	return v.aead.Open(nil, nonce, sealed, nil)
+}
@@ -0,0 +1,5 @@
+DROP TABLE IF EXISTS tokens_permissions;
+
+DELETE FROM tokens WHERE expiry IS NULL;
+ALTER TABLE tokens DROP COLUMN IF EXISTS name;
+ALTER TABLE tokens ALTER COLUMN expiry SET NOT NULL;
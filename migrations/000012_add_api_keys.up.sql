@@ -0,0 +1,8 @@
+ALTER TABLE tokens ALTER COLUMN expiry DROP NOT NULL;
+ALTER TABLE tokens ADD COLUMN IF NOT EXISTS name text NOT NULL DEFAULT '';
+
+CREATE TABLE IF NOT EXISTS tokens_permissions (
+token_id bigint NOT NULL REFERENCES tokens(id) ON DELETE CASCADE,
+permission_id bigint NOT NULL REFERENCES permissions ON DELETE CASCADE,
+PRIMARY KEY (token_id, permission_id)
+);
@@ -0,0 +1,16 @@
+CREATE TABLE IF NOT EXISTS user_mfa(
+user_id bigint PRIMARY KEY REFERENCES users ON DELETE CASCADE,
+secret bytea NOT NULL,
+enabled bool NOT NULL DEFAULT false,
+last_used_step bigint NOT NULL DEFAULT 0,
+failed_attempts integer NOT NULL DEFAULT 0,
+created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+confirmed_at timestamp(0) with time zone);
+
+CREATE TABLE IF NOT EXISTS mfa_recovery_codes(
+id bigserial PRIMARY KEY,
+user_id bigint NOT NULL REFERENCES users ON DELETE CASCADE,
+hash bytea NOT NULL,
+used_at timestamp(0) with time zone,
+UNIQUE (user_id, hash)
+);
@@ -0,0 +1,5 @@
+CREATE TABLE IF NOT EXISTS login_throttles(
+key text PRIMARY KEY,
+failures integer NOT NULL DEFAULT 0,
+locked_until timestamp(0) with time zone,
+updated_at timestamp(0) with time zone NOT NULL DEFAULT NOW());
@@ -0,0 +1,18 @@
+CREATE TABLE IF NOT EXISTS user_identities(
+id bigserial PRIMARY KEY,
+user_id bigint NOT NULL REFERENCES users ON DELETE CASCADE,
+issuer text NOT NULL,
+subject text NOT NULL,
+email text NOT NULL DEFAULT '',
+created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+UNIQUE (issuer, subject)
+);
+
+CREATE INDEX IF NOT EXISTS user_identities_user_id_idx ON user_identities(user_id);
+
+CREATE TABLE IF NOT EXISTS oidc_auth_requests(
+state_hash bytea PRIMARY KEY,
+nonce text NOT NULL,
+code_verifier text NOT NULL,
+expiry timestamp(0) with time zone NOT NULL
+);
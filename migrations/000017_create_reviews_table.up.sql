@@ -0,0 +1,17 @@
+CREATE TABLE IF NOT EXISTS reviews(
+id bigserial UNIQUE,
+user_id bigint NOT NULL,
+movie_id bigint NOT NULL,
+title text NOT NULL DEFAULT '',
+body text NOT NULL,
+spoiler bool NOT NULL DEFAULT false,
+helpful_votes integer NOT NULL DEFAULT 0,
+unhelpful_votes integer NOT NULL DEFAULT 0,
+created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+edited_at timestamp(0) with time zone,
+version integer NOT NULL DEFAULT 1,
+PRIMARY KEY (user_id, movie_id),
+FOREIGN KEY (user_id, movie_id) REFERENCES ratings (user_id, movie_id) ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS reviews_movie_id_idx ON reviews(movie_id, created_at);
@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS reviews_movie_id_helpful_score_idx;
+ALTER TABLE reviews DROP COLUMN IF EXISTS helpful_score;
+DROP TABLE IF EXISTS review_votes;
@@ -0,0 +1,12 @@
+CREATE TABLE IF NOT EXISTS review_votes(
+review_id bigint NOT NULL REFERENCES reviews(id) ON DELETE CASCADE,
+user_id bigint NOT NULL REFERENCES users ON DELETE CASCADE,
+helpful bool NOT NULL,
+created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+updated_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+PRIMARY KEY (review_id, user_id)
+);
+
+ALTER TABLE reviews ADD COLUMN IF NOT EXISTS helpful_score double precision NOT NULL DEFAULT 0;
+
+CREATE INDEX IF NOT EXISTS reviews_movie_id_helpful_score_idx ON reviews(movie_id, helpful_score DESC);
@@ -0,0 +1,5 @@
+DROP TABLE IF EXISTS moderation_actions;
+DROP TABLE IF EXISTS moderation_queue;
+DROP TABLE IF EXISTS content_flags;
+ALTER TABLE reviews DROP COLUMN IF EXISTS status;
+DELETE FROM permissions WHERE code = 'content:moderate';
@@ -0,0 +1,38 @@
+INSERT INTO permissions (code)
+VALUES
+('content:moderate')
+ON CONFLICT (code) DO NOTHING;
+
+ALTER TABLE reviews ADD COLUMN IF NOT EXISTS status text NOT NULL DEFAULT 'published';
+
+CREATE TABLE IF NOT EXISTS content_flags(
+id bigserial PRIMARY KEY,
+content_type text NOT NULL,
+content_id bigint NOT NULL,
+user_id bigint NOT NULL REFERENCES users ON DELETE CASCADE,
+reason text NOT NULL,
+created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+resolved_at timestamp(0) with time zone,
+UNIQUE (content_type, content_id, user_id)
+);
+
+CREATE TABLE IF NOT EXISTS moderation_queue(
+content_type text NOT NULL,
+content_id bigint NOT NULL,
+reason text NOT NULL,
+created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+updated_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+PRIMARY KEY (content_type, content_id)
+);
+
+CREATE TABLE IF NOT EXISTS moderation_actions(
+id bigserial PRIMARY KEY,
+content_type text NOT NULL,
+content_id bigint NOT NULL,
+moderator_id bigint REFERENCES users ON DELETE SET NULL,
+action text NOT NULL,
+note text NOT NULL DEFAULT '',
+created_at timestamp(0) with time zone NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS moderation_actions_content_idx ON moderation_actions(content_type, content_id);
@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS movies_rating_count_idx;
+ALTER TABLE movies DROP COLUMN IF EXISTS rating_count;
+ALTER TABLE movies DROP COLUMN IF EXISTS rating_sum;
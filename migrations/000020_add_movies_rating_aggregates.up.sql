@@ -0,0 +1,8 @@
+ALTER TABLE movies ADD COLUMN IF NOT EXISTS rating_sum double precision NOT NULL DEFAULT 0;
+ALTER TABLE movies ADD COLUMN IF NOT EXISTS rating_count integer NOT NULL DEFAULT 0;
+
+UPDATE movies SET rating_sum = aggregates.rating_sum, rating_count = aggregates.rating_count
+FROM (SELECT movie_id, sum(rating) AS rating_sum, count(rating) AS rating_count FROM ratings GROUP BY movie_id) AS aggregates
+WHERE movies.id = aggregates.movie_id;
+
+CREATE INDEX IF NOT EXISTS movies_rating_count_idx ON movies(rating_count);
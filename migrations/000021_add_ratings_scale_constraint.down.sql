@@ -0,0 +1,2 @@
+ALTER TABLE ratings DROP CONSTRAINT IF EXISTS ratings_rating_check;
+ALTER TABLE ratings ALTER COLUMN rating DROP NOT NULL;
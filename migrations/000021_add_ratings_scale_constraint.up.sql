@@ -0,0 +1,14 @@
+-- Ratings without a value were never usable, and their reviews go with them.
+DELETE FROM ratings WHERE rating IS NULL;
+
+-- Clamp everything else onto the 1 to 10 scale in steps of 0.5.
+UPDATE ratings SET rating = LEAST(GREATEST(round(rating * 2) / 2, 1), 10)
+WHERE rating <> LEAST(GREATEST(round(rating * 2) / 2, 1), 10);
+
+UPDATE movies SET rating_sum = aggregates.rating_sum, rating_count = aggregates.rating_count
+FROM (SELECT movies.id, COALESCE(sum(ratings.rating), 0) AS rating_sum, count(ratings.rating) AS rating_count
+	FROM movies LEFT JOIN ratings ON ratings.movie_id = movies.id GROUP BY movies.id) AS aggregates
+WHERE movies.id = aggregates.id;
+
+ALTER TABLE ratings ALTER COLUMN rating SET NOT NULL;
+ALTER TABLE ratings ADD CONSTRAINT ratings_rating_check CHECK (rating BETWEEN 1 AND 10 AND rating * 2 = round(rating * 2));
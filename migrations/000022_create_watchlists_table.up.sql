@@ -0,0 +1,8 @@
+CREATE TABLE IF NOT EXISTS watchlists(
+user_id bigint NOT NULL REFERENCES users ON DELETE CASCADE,
+movie_id bigint NOT NULL REFERENCES movies ON DELETE CASCADE,
+added_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+PRIMARY KEY (user_id, movie_id)
+);
+
+CREATE INDEX IF NOT EXISTS watchlists_user_id_added_at_idx ON watchlists(user_id, added_at);
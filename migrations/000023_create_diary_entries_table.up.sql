@@ -0,0 +1,12 @@
+CREATE TABLE IF NOT EXISTS diary_entries(
+id bigserial PRIMARY KEY,
+user_id bigint NOT NULL REFERENCES users ON DELETE CASCADE,
+movie_id bigint NOT NULL REFERENCES movies ON DELETE CASCADE,
+watched_on date NOT NULL,
+rewatch bool NOT NULL DEFAULT false,
+rating double precision CHECK (rating IS NULL OR (rating BETWEEN 1 AND 10 AND rating * 2 = round(rating * 2))),
+note text NOT NULL DEFAULT '',
+created_at timestamp(0) with time zone NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS diary_entries_user_id_watched_on_idx ON diary_entries(user_id, watched_on);
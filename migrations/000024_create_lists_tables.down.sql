@@ -0,0 +1,4 @@
+DELETE FROM moderation_queue WHERE content_type = 'list';
+DELETE FROM content_flags WHERE content_type = 'list';
+DROP TABLE IF EXISTS list_entries;
+DROP TABLE IF EXISTS lists;
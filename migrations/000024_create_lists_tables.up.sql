@@ -0,0 +1,24 @@
+CREATE TABLE IF NOT EXISTS lists(
+id bigserial PRIMARY KEY,
+user_id bigint NOT NULL REFERENCES users ON DELETE CASCADE,
+name text NOT NULL,
+description text NOT NULL DEFAULT '',
+public bool NOT NULL DEFAULT false,
+status text NOT NULL DEFAULT 'published',
+created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+updated_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+version integer NOT NULL DEFAULT 1
+);
+
+CREATE INDEX IF NOT EXISTS lists_user_id_idx ON lists(user_id);
+CREATE INDEX IF NOT EXISTS lists_public_updated_at_idx ON lists(updated_at) WHERE public AND status = 'published';
+
+CREATE TABLE IF NOT EXISTS list_entries(
+list_id bigint NOT NULL REFERENCES lists ON DELETE CASCADE,
+movie_id bigint NOT NULL REFERENCES movies ON DELETE CASCADE,
+position integer NOT NULL CHECK (position > 0),
+note text NOT NULL DEFAULT '',
+added_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+PRIMARY KEY (list_id, movie_id),
+UNIQUE (list_id, position) DEFERRABLE INITIALLY DEFERRED
+);
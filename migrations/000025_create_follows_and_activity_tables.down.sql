@@ -0,0 +1,3 @@
+DROP TABLE IF EXISTS activity_events;
+DROP TABLE IF EXISTS follows;
+ALTER TABLE users DROP COLUMN IF EXISTS private;
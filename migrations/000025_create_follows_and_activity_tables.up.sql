@@ -0,0 +1,26 @@
+ALTER TABLE users ADD COLUMN IF NOT EXISTS private bool NOT NULL DEFAULT false;
+
+CREATE TABLE IF NOT EXISTS follows(
+follower_id bigint NOT NULL REFERENCES users ON DELETE CASCADE,
+followee_id bigint NOT NULL REFERENCES users ON DELETE CASCADE,
+status text NOT NULL DEFAULT 'accepted',
+created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+accepted_at timestamp(0) with time zone,
+PRIMARY KEY (follower_id, followee_id),
+CHECK (follower_id <> followee_id)
+);
+
+CREATE INDEX IF NOT EXISTS follows_followee_id_idx ON follows(followee_id, status);
+
+CREATE TABLE IF NOT EXISTS activity_events(
+id bigserial PRIMARY KEY,
+user_id bigint NOT NULL REFERENCES users ON DELETE CASCADE,
+kind text NOT NULL,
+movie_id bigint REFERENCES movies ON DELETE CASCADE,
+list_id bigint REFERENCES lists ON DELETE CASCADE,
+diary_entry_id bigint REFERENCES diary_entries ON DELETE CASCADE,
+rating double precision,
+created_at timestamp(0) with time zone NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS activity_events_user_id_id_idx ON activity_events(user_id, id DESC);
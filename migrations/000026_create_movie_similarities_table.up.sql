@@ -0,0 +1,10 @@
+CREATE TABLE IF NOT EXISTS movie_similarities(
+movie_id bigint NOT NULL REFERENCES movies ON DELETE CASCADE,
+similar_movie_id bigint NOT NULL REFERENCES movies ON DELETE CASCADE,
+similarity double precision NOT NULL,
+co_ratings integer NOT NULL,
+computed_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+PRIMARY KEY (movie_id, similar_movie_id)
+);
+
+CREATE INDEX IF NOT EXISTS movie_similarities_movie_id_similarity_idx ON movie_similarities(movie_id, similarity DESC);
@@ -0,0 +1,9 @@
+CREATE TABLE IF NOT EXISTS movie_activity(
+movie_id bigint NOT NULL REFERENCES movies ON DELETE CASCADE,
+kind text NOT NULL CHECK (kind IN ('view', 'rating', 'review', 'watchlist')),
+bucket timestamp(0) with time zone NOT NULL,
+count integer NOT NULL CHECK (count > 0),
+PRIMARY KEY (movie_id, kind, bucket)
+);
+
+CREATE INDEX IF NOT EXISTS movie_activity_bucket_idx ON movie_activity(bucket);
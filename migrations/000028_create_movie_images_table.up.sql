@@ -0,0 +1,8 @@
+CREATE TABLE IF NOT EXISTS movie_images(
+id bigserial PRIMARY KEY,
+movie_id bigint NOT NULL REFERENCES movies ON DELETE CASCADE,
+kind text NOT NULL CHECK (kind IN ('poster', 'backdrop')),
+variants jsonb NOT NULL,
+created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+UNIQUE (movie_id, kind)
+);